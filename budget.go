@@ -0,0 +1,45 @@
+package hedged
+
+import "sync/atomic"
+
+// Budget is a fixed pool of hedge capacity, shared through Options.Budget by
+// any number of runs and Hedgers, to cap the hedges in flight at once across
+// all of them, rather than their rate, as a Limiter would. A Group hedges
+// under one, for runs configured alike, but the runs sharing a Budget may
+// each be configured differently.
+//
+// A Budget is safe for concurrent use.
+type Budget struct {
+	sem chan struct{}
+
+	// denied counts the calls to Allow that found no capacity left, for
+	// GroupStats.
+	denied int64
+}
+
+// NewBudget returns a Budget allowing at most n hedges in flight at once.
+func NewBudget(n int) *Budget {
+	return &Budget{sem: make(chan struct{}, n)}
+}
+
+// Allow takes capacity for a hedge, if any is left, reporting whether it did.
+// It never blocks.
+func (b *Budget) Allow() bool {
+	select {
+	case b.sem <- struct{}{}:
+		return true
+	default:
+		atomic.AddInt64(&b.denied, 1)
+		return false
+	}
+}
+
+// Return gives back the capacity taken by a successful Allow.
+func (b *Budget) Return() {
+	<-b.sem
+}
+
+// InFlight returns the capacity taken so far and not given back.
+func (b *Budget) InFlight() int {
+	return len(b.sem)
+}
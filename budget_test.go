@@ -0,0 +1,69 @@
+package hedged
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(1)
+	var p peak
+	var hedges int32
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			time.Sleep(20 * time.Millisecond)
+			return "original", nil
+		}
+		atomic.AddInt32(&hedges, 1)
+		p.enter()
+		defer p.exit()
+		time.Sleep(10 * time.Millisecond)
+		return "hedge", nil
+	})
+	// Two differently configured Hedgers share the one budget.
+	hs := []*Hedger{
+		New(WithWait(1*time.Millisecond), WithBudget(b)),
+		New(WithWait(2*time.Millisecond), WithN(2), WithBudget(b)),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, h := range hs {
+			wg.Add(1)
+			go func(h *Hedger) {
+				defer wg.Done()
+				if _, err := h.Run(context.TODO(), r); err != nil {
+					t.Error(err)
+				}
+			}(h)
+		}
+	}
+	wg.Wait()
+	// Losing hedges may still be running.
+	if n := p.max(); n != 1 {
+		t.Errorf("Expected at most 1 hedge in flight, got %d", n)
+	}
+	if atomic.LoadInt32(&hedges) == 0 {
+		t.Error("Expected some hedges sent")
+	}
+	settle(t)
+	if n := b.InFlight(); n != 0 {
+		t.Errorf("Expected the budget given back, got %d in flight", n)
+	}
+}
+
+func TestBudgetLimiter(t *testing.T) {
+	b := NewBudget(1)
+	deny := &denyAll{}
+	opts := Options{Budget: b, Limiter: deny}
+	RunOptions(context.TODO(), 0, 1, &str{"howdy"}, opts)
+	if deny.calls != 1 {
+		t.Fatalf("Expected the Limiter asked once, got %d", deny.calls)
+	}
+	// The capacity taken before the Limiter denied the hedge was given back.
+	if n := b.InFlight(); n != 0 {
+		t.Errorf("Expected nothing in flight, got %d", n)
+	}
+}
@@ -0,0 +1,39 @@
+// Package errgroup runs hedged requests as part of an errgroup.Group, from
+// golang.org/x/sync/errgroup, kept apart so that package hedged doesn't depend
+// on it.
+//
+// The group's context governs the requests: a failure elsewhere in the group
+// cancels them, and their failure cancels the rest of the group. Import it
+// under another name than x/sync's, e.g. hedgederrgroup:
+//
+//	g, ctx := errgroup.WithContext(ctx)
+//	a := hedgederrgroup.RunInGroup(ctx, wait, 1, backendA, g)
+//	b := hedgederrgroup.RunInGroup(ctx, wait, 1, backendB, g)
+//	if err := g.Wait(); err != nil {
+//		return err
+//	}
+//	// Use a.Value and b.Value.
+package errgroup
+
+import (
+	"context"
+	"time"
+
+	"github.com/luciferous/hedged"
+	"golang.org/x/sync/errgroup"
+)
+
+// RunInGroup runs the request in a goroutine of g, hedged as by
+// hedged.RunResult, and returns its Result, which is only filled in once that
+// goroutine ends: read it after g.Wait returns. An error is returned to g,
+// cancelling the group's context if g came from errgroup.WithContext; pass
+// that context as ctx so that the failure of any other goroutine of the group
+// cancels the requests in turn.
+func RunInGroup(ctx context.Context, wait time.Duration, n int, r hedged.Request, g *errgroup.Group) *hedged.Result {
+	res := new(hedged.Result)
+	g.Go(func() error {
+		*res = hedged.RunResult(ctx, wait, n, r)
+		return res.Err
+	})
+	return res
+}
@@ -0,0 +1,42 @@
+package errgroup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/luciferous/hedged"
+	"golang.org/x/sync/errgroup"
+)
+
+var errHowdy = errors.New("howdy")
+
+func TestRunInGroup(t *testing.T) {
+	g, ctx := errgroup.WithContext(context.TODO())
+	res := RunInGroup(ctx, 1*time.Millisecond, 1, hedged.RequestFunc(func(ctx context.Context) (interface{}, error) {
+		return "howdy", nil
+	}), g)
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if res.Value != "howdy" {
+		t.Errorf("Expected howdy, got %+v", res)
+	}
+}
+
+func TestRunInGroupCancel(t *testing.T) {
+	g, ctx := errgroup.WithContext(context.TODO())
+	// A sibling's failure cancels the hedged requests.
+	g.Go(func() error { return errHowdy })
+	res := RunInGroup(ctx, 1*time.Millisecond, 1, hedged.RequestFunc(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}), g)
+	if err := g.Wait(); err != errHowdy {
+		t.Errorf("Expected errHowdy, got %v", err)
+	}
+	if res.Err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %+v", res)
+	}
+}
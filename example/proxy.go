@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"github.com/luciferous/hedged"
 	"io"
@@ -9,26 +8,25 @@ import (
 	"time"
 )
 
-type req struct{}
-
-func (r req) Req(ctx context.Context) (interface{}, error) {
-	req, err := http.NewRequest("GET", "http://localhost:8000", nil)
-	if err != nil {
-		return nil, err
-	}
-	req = req.WithContext(ctx)
-	return http.DefaultClient.Do(req)
+var client = &http.Client{
+	Transport: &hedged.Transport{Wait: 100 * time.Millisecond},
 }
 
 func hedgedApp(w http.ResponseWriter, r *http.Request) {
-	switch v := hedged.Run(r.Context(), 100*time.Millisecond, req{}).(type) {
-	case error:
-		http.Error(w, v.Error(), 503)
-	case *http.Response:
-		r.Body.Close()
-		w.WriteHeader(v.StatusCode)
-		io.Copy(w, v.Body)
+	req, err := http.NewRequestWithContext(r.Context(), "GET", "http://localhost:8000", nil)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), 503)
+		return
 	}
+	defer resp.Body.Close()
+	r.Body.Close()
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
 }
 
 func main() {
@@ -0,0 +1,56 @@
+package hedged
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Group runs many independent requests, hedging them under a budget shared by
+// all of them, rather than per call: at most a given number of hedges are in
+// flight across the group at once. Hedges beyond it are skipped, as if denied
+// by Options.Limiter. The budget is a Budget, set as Options.Budget in place
+// of any configured.
+//
+// A Group is safe for concurrent use.
+type Group struct {
+	h      *Hedger
+	budget *Budget
+
+	runs int64
+}
+
+// GroupStats counts the requests run by a Group.
+type GroupStats struct {
+	// Runs is the number of calls to Run.
+	Runs int64
+
+	// Hedges is the number of hedges sent.
+	Hedges int64
+
+	// Skipped is the number of hedges skipped for lack of budget.
+	Skipped int64
+}
+
+// NewGroup returns a Group allowing at most maxHedges hedges in flight at
+// once, and running each request as a Hedger configured by opts would.
+func NewGroup(maxHedges int, opts ...Option) *Group {
+	g := &Group{h: New(opts...), budget: NewBudget(maxHedges)}
+	g.h.Options.Budget = g.budget
+	return g
+}
+
+// Run sends the request as configured, within the Group's budget, and returns
+// the value and error of the winning request.
+func (g *Group) Run(ctx context.Context, r Request) (interface{}, error) {
+	atomic.AddInt64(&g.runs, 1)
+	return g.h.Run(ctx, r)
+}
+
+// Stats returns the counts so far.
+func (g *Group) Stats() GroupStats {
+	return GroupStats{
+		Runs:    atomic.LoadInt64(&g.runs),
+		Hedges:  atomic.LoadInt64(&g.h.hedges),
+		Skipped: atomic.LoadInt64(&g.budget.denied),
+	}
+}
@@ -0,0 +1,60 @@
+package hedged
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroup(t *testing.T) {
+	var p peak
+	g := NewGroup(2, WithWait(1*time.Millisecond))
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			time.Sleep(20 * time.Millisecond)
+			return "original", nil
+		}
+		p.enter()
+		defer p.exit()
+		time.Sleep(10 * time.Millisecond)
+		return "hedge", nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.Run(context.TODO(), r); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if most := p.max(); most > 2 {
+		t.Errorf("Expected at most 2 hedges in flight, got %d", most)
+	}
+	stats := g.Stats()
+	if stats.Runs != 10 || stats.Hedges+stats.Skipped != 10 || stats.Hedges < 2 {
+		t.Errorf("Expected 10 runs with 10 hedges sent or skipped, got %+v", stats)
+	}
+}
+
+func TestGroupLimiter(t *testing.T) {
+	deny := &denyAll{}
+	g := NewGroup(1, WithWait(0), WithLimiter(deny))
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		time.Sleep(5 * time.Millisecond)
+		return "howdy", nil
+	})
+	if v, err := g.Run(context.TODO(), r); err != nil || v != "howdy" {
+		t.Fatalf("Expected howdy, got %v, %v", v, err)
+	}
+	if deny.calls != 1 {
+		t.Fatalf("Expected the Limiter asked once, got %d", deny.calls)
+	}
+	// Denied by the Limiter, not for lack of budget.
+	if stats := g.Stats(); stats.Hedges != 0 || stats.Skipped != 0 {
+		t.Errorf("Expected no hedges, none skipped for budget, got %+v", stats)
+	}
+}
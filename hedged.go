@@ -40,7 +40,15 @@ package hedged
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -53,22 +61,27 @@ import (
 // Via the supplied context, implementations may respond directly to
 // cancellation from the caller,
 //
-// 	func Req(ctx context.Context) (interface{}, error) {
-// 		select {
-// 		case <-ctx.Done():
-// 			// Canceled: do something else, clean up, etc...
-// 		}
-// 	}
+//	func Req(ctx context.Context) (interface{}, error) {
+//		select {
+//		case <-ctx.Done():
+//			// Canceled: do something else, clean up, etc...
+//		}
+//	}
 //
 // or propagate it by passing the context forward, allowing subsequent
 // computations to respond instead,
 //
-// 	func Req(ctx context.Context) (interface{}, error) {
-// 		req, err := http.NewRequest("GET", "http://example.com", nil)
-// 		// if err != nil ...
-// 		req = req.WithContext(ctx)
-// 		return http.DefaultClient.Do(req)
-// 	}
+//	func Req(ctx context.Context) (interface{}, error) {
+//		req, err := http.NewRequest("GET", "http://example.com", nil)
+//		// if err != nil ...
+//		req = req.WithContext(ctx)
+//		return http.DefaultClient.Do(req)
+//	}
+//
+// The context of every request, the winner's included, is cancelled by the
+// time Run returns, so values that remain tied to it, like the body of an
+// *http.Response, are unusable afterwards. Use RunStreaming to keep the
+// winner's context alive.
 type Request interface {
 	Req(context.Context) (interface{}, error)
 }
@@ -81,68 +94,1718 @@ func (f RequestFunc) Req(ctx context.Context) (interface{}, error) {
 	return f(ctx)
 }
 
+// Func adapts a function returning a concrete type to a Request, sparing a
+// wrapper to box its value. See RunTyped to get the value back unboxed.
+func Func[T any](f func(context.Context) (T, error)) Request {
+	return RequestFunc(func(ctx context.Context) (interface{}, error) {
+		return f(ctx)
+	})
+}
+
+// Retry wraps r so that each request retries it on its own, up to attempts
+// calls in all, as long as it fails with an error isRetryable matches, e.g. a
+// transient dial error, before reporting to the run. Nil isRetryable retries
+// every error. Retries stop once the request's context is done, so that a
+// winning sibling cuts them short, and the last error is then returned.
+func Retry(r Request, attempts int, isRetryable func(error) bool) Request {
+	return RequestFunc(func(ctx context.Context) (interface{}, error) {
+		v, err := r.Req(ctx)
+		for i := 1; i < attempts && err != nil && ctx.Err() == nil; i++ {
+			if isRetryable != nil && !isRetryable(err) {
+				break
+			}
+			v, err = r.Req(ctx)
+		}
+		return v, err
+	})
+}
+
 // Run sends the request.
 //
 // If the request doesn't complete within the wait time, another request is
 // sent as a backup. Whichever request completes first cancels the other.
+//
+// The result is either the value or the error returned by the winning request.
+// Use RunE to receive them separately.
 func Run(ctx context.Context, wait time.Duration, r Request) interface{} {
 	return RunN(ctx, wait, 1, r)
 }
 
 // RunN is like Run but can send more than one hedge request.
 //
-// The wait duration is the interval at which requests get sent, until one
-// completes, or there are n requests in flight. Whichever request completes
-// first cancels the rest.
+// The original request is sent immediately, followed by up to n hedge
+// requests, one every wait interval, until one completes. At most n+1 requests
+// are sent in total; with n == 0 only the original is sent, as it is with a
+// negative n, which is treated as 0. Whichever request completes first cancels
+// the rest.
+//
+// A wait of zero or less sends all n+1 requests at once, before any result is
+// considered; see RunConcurrent.
 func RunN(ctx context.Context, wait time.Duration, n int, r Request) interface{} {
-	var wg sync.WaitGroup
-	var v interface{}
+	return RunResult(ctx, wait, n, r).value()
+}
+
+// RunConcurrent is like RunN but sends all n+1 requests at once, as RunN does
+// with a wait of zero, rather than hedging after a wait.
+func RunConcurrent(ctx context.Context, n int, r Request) interface{} {
+	return RunN(ctx, 0, n, r)
+}
+
+// RunE is like Run but returns the value and error of the winning request
+// separately, as the request itself returned them.
+func RunE(ctx context.Context, wait time.Duration, r Request) (interface{}, error) {
+	return RunNE(ctx, wait, 1, r)
+}
+
+// RunNE is like RunN but returns the value and error of the winning request
+// separately, as the request itself returned them.
+//
+// If ctx is done before any request completes, the value is nil and the error
+// is ctx.Err().
+func RunNE(ctx context.Context, wait time.Duration, n int, r Request) (interface{}, error) {
+	res := RunResult(ctx, wait, n, r)
+	return res.Value, res.Err
+}
+
+// RunResult is like RunN but returns the outcome of the winning request as a
+// Result, so that a value implementing error is never mistaken for a failure.
+func RunResult(ctx context.Context, wait time.Duration, n int, r Request) Result {
+	return run(ctx, wait, n, r, &noOptions).Result
+}
+
+// noOptions is shared by the runs without options, sparing an allocation
+// each: runs never write to their options.
+var noOptions Options
+
+// Options customize how requests are run.
+//
+// The zero value runs requests exactly like RunNE.
+type Options struct {
+	// Discard, if set, is called with the value of every request that
+	// completes without error but doesn't win, e.g. to release resources held
+	// by the value. It may be called after RunOptions returns.
+	Discard func(interface{})
+
+	// AutoCloseLosers closes the value of every request that completes
+	// without error but doesn't win, if it is an io.Closer, after any
+	// Discard. This saves wiring up a Discard just to close the value.
+	AutoCloseLosers bool
+
+	// KeepLosers lets the requests still in flight once a winner is chosen
+	// run to completion, e.g. to warm caches downstream, rather than
+	// cancelling them. They are reaped in the background as usual, and
+	// their contexts cancelled once reaped, or once ReapTimeout elapses.
+	//
+	// Every request sent then runs its course, holding its resources and a
+	// goroutine meanwhile, so hedging costs its full extra load. Losers still
+	// end with ctx, and Timeout: to outlive the call, pass a ctx that does,
+	// e.g. from context.WithoutCancel. If ctx is done before a winner is
+	// chosen, every request is cancelled regardless.
+	KeepLosers bool
+
+	// Cleanup, if set, is called exactly once for every request that
+	// returned, winner and losers alike, whatever its error, once its result
+	// is done with, e.g. to give back a pooled connection the request
+	// acquired. For losers that is after any Discard, possibly after
+	// RunOptions returns, even past ReapTimeout, in which case it comes from
+	// the request's own goroutine. For the winner it is right before
+	// RunOptions returns, so the value must remain usable without what
+	// Cleanup releases.
+	Cleanup func(attempt int, v interface{}, err error)
+
+	// OnSend, if set, is called with the index of each request right before
+	// it is sent: 0 for the original, 1 for the first hedge, and so on.
+	OnSend func(attempt int)
+
+	// OnComplete, if set, is called when each request returns, with its
+	// index, error and duration, e.g. to estimate latency percentiles. It is
+	// called for every request sent, losers included, whatever they return
+	// once cancelled.
+	//
+	// Calls come from each request's own goroutine, so may be concurrent.
+	// The call for a request follows OnSend for it, and precedes its result
+	// being considered, so the winner's call precedes RunOptions returning.
+	// The calls for losers may follow it, even past ReapTimeout.
+	OnComplete func(attempt int, err error, d time.Duration)
+
+	// OnSavings, if set, is called once for each run with a winner, with how
+	// much earlier the winner completed than the last request to complete,
+	// or zero if none completed later, and whether any hedges were sent.
+	//
+	// Losers are waited on for it, so it is called by the reaper once they
+	// have all returned, or once ReapTimeout elapses, counting only those
+	// returned by then. Losers are cancelled when the winner completes, so
+	// unless they ignore cancellation, saved understates how much later the
+	// original would have completed.
+	OnSavings func(saved time.Duration, hedged bool)
+
+	// Timeout, if positive, bounds the whole run, however many hedges are
+	// sent. Once it elapses the error is a *TimeoutError, telling it apart
+	// from the caller's ctx being done, whose error is returned unchanged.
+	// Requests learn it from context.Cause.
+	Timeout time.Duration
+
+	// AttemptTimeout, if positive, bounds each request on its own, so that a
+	// stuck one can't hold on to resources while the run goes on. The
+	// context of a request is done once it elapses, reporting
+	// context.DeadlineExceeded, with ErrHedgeTimeout as its cause, as told by
+	// context.Cause, and the request then loses, whatever it returns, with
+	// the next hedge sent right away, as with RetryOn. A request ignoring its
+	// context can't be stopped, though.
+	AttemptTimeout time.Duration
+
+	// Jitter, if set, randomizes the wait before each hedge, so that many
+	// callers hedging against the same backend don't do so in lockstep. It is
+	// called once per hedge with the wait and returns the delay to use
+	// instead. See FullJitter.
+	Jitter func(base time.Duration) time.Duration
+
+	// Backoff, if set, spaces hedges out as they accumulate. It is called
+	// with the index of each hedge, 1 for the first, and the wait, and
+	// returns the delay before that hedge, to which any Jitter then applies.
+	// Nil keeps the delay constant. See ExponentialBackoff.
+	Backoff func(attempt int, base time.Duration) time.Duration
+
+	// InitialDelay, if positive, delays the original request, e.g. to give a
+	// cache check the chance to answer first, or to stagger load on a cold
+	// start. The run still ends as soon as ctx is done, or Timeout elapses,
+	// meanwhile. Hedges are paced from when the original is sent.
+	InitialDelay time.Duration
+
+	// MaxInFlight, if positive, caps how many requests may be in flight at
+	// once. A hedge due while at the cap is sent once a request completes
+	// without winning, e.g. by failing under RunFirstSuccess. Since otherwise
+	// the first completion wins, a cap of 1 disables hedging.
+	//
+	// It also bounds the goroutines a run spawns, however large n and small
+	// the wait: each request holds its slot until its result is received,
+	// so at most MaxInFlight run at once, while n still bounds how many are
+	// sent in total.
+	MaxInFlight int
+
+	// Limiter, if set, must allow each hedge before it is sent; a denied
+	// hedge is skipped. The original request is always sent. This bounds
+	// duplicated work across many runs sharing the Limiter, e.g. a
+	// *rate.Limiter from golang.org/x/time/rate.
+	Limiter Limiter
+
+	// Budget, if set, caps the hedges in flight at once, across every run
+	// sharing it, e.g. process-wide. Each hedge takes capacity from it
+	// before being sent, and gives it back once it returns; a hedge finding
+	// none left is skipped.
+	Budget *Budget
+
+	// Gauge, if set, tells the current load of the backend, from 0 for idle
+	// to 1 for saturated. It is read right before each hedge, and once it is
+	// above MaxLoad, the hedge and all the rest of the run's are skipped, so
+	// as not to add duplicate work to a struggling backend. The original
+	// request is always sent.
+	Gauge func() float64
+
+	// MaxLoad is the load above which Gauge suppresses hedges. Zero means
+	// DefaultMaxLoad.
+	MaxLoad float64
+
+	// ShouldHedge, if set, is asked before each hedge whether to send it,
+	// e.g. by a circuit breaker that opens when backends are unhealthy; if
+	// not, the hedge is skipped. The original request is always sent.
+	ShouldHedge func() bool
+
+	// MinUseful, if positive, is the least time a request needs to be of
+	// use: a hedge is skipped if ctx, as bounded by Timeout, has a deadline
+	// sooner than that, since it couldn't complete in time.
+	MinUseful time.Duration
+
+	// DeadlineMargin, if positive, shortens the deadline of each hedge, if
+	// ctx has one, as bounded by Timeout: a hedge sent some time into the
+	// run gets a deadline sooner by that time plus the margin, so that the
+	// later a hedge, the sooner it fails, rather than racing a deadline it
+	// can't meet. A hedge whose shortened deadline passes then loses,
+	// whatever it returns. A hedge left with no time at all is skipped, as
+	// is one left with less than MinUseful. The original request keeps the
+	// full deadline. Like deadlines, the time into the run is told by the
+	// time package, whatever the Clock.
+	DeadlineMargin time.Duration
+
+	// firstSuccess makes errors lose, see RunFirstSuccess.
+	firstSuccess bool
+
+	// RetryOn, if set, makes hedging double as retrying: a request failing
+	// with an error it matches doesn't win, and the next hedge is sent right
+	// away rather than after the wait. Other errors win as usual.
+	RetryOn func(error) bool
+
+	// FailFastQuorum, if positive, gives up on a backend that is clearly
+	// broken: once that many requests have failed, the rest are cancelled,
+	// no more hedges are sent, and the run returns the Errors so far, nil
+	// for the requests still in flight. It matters only where errors lose,
+	// as with RetryOn or RunFirstSuccess; otherwise the first error wins.
+	FailFastQuorum int
+
+	// Validate, if set, checks the value of each request that completes
+	// without error, e.g. for a stale version. A value it rejects doesn't
+	// win, and the next hedge is sent right away, as with RetryOn. If every
+	// request loses, the last value rejected is returned with
+	// ErrNoValidResult. Calls come from each request's own goroutine, so may
+	// be concurrent.
+	Validate func(interface{}) bool
+
+	// Acceptable, if set, checks the value and error of each request, e.g.
+	// an *http.Response with a 5xx status. A result it doesn't accept
+	// doesn't win, but unlike with Validate, hedging goes on at its usual
+	// pace. If every request loses, the last result not accepted is
+	// returned, with its value and ErrNoAcceptableResult, wrapping its
+	// error if any. Values Validate rejects aren't passed to it. Calls come
+	// from each request's own goroutine, so may be concurrent.
+	Acceptable func(v interface{}, err error) bool
+
+	// Rank, if set, picks which result to return when Acceptable accepts
+	// none: the top-ranked of those it didn't accept, rather than the last.
+	// It returns a positive number if a ranks above b, a negative one if
+	// below, and zero if they rank the same, in which case the earlier
+	// result received is kept. It is given the values of the results, and
+	// is called from the run's own goroutine as they are received.
+	Rank func(a, b interface{}) int
+
+	// Grace, if positive, is how long to wait after the first result for a
+	// better one, as judged by Prefer, or else PreferAttempt, before
+	// returning. Results arriving meanwhile are offered to Prefer in turn.
+	// Zero returns the first result right away.
+	Grace time.Duration
+
+	// Prefer picks the better of two results, returning one of them. Nil
+	// leaves the choice to PreferAttempt with Grace, and keeps the first
+	// without. Without Grace, it still breaks ties between results that have
+	// already arrived by the time the first is received, e.g. to favor the
+	// original request over a hedge completing alongside it.
+	Prefer func(a, b Result) Result
+
+	// PreferAttempt is, with Grace and no Prefer, the index of the request
+	// whose result to pick, if it succeeds, over any other: 0 for the
+	// original, e.g. to favor a strongly consistent primary over hedges to
+	// eventually consistent secondaries. A negative index, such as -1,
+	// prefers none, keeping the first result.
+	//
+	// The first result is then held for up to Grace, or until every
+	// request has returned, in case the preferred request succeeds
+	// meanwhile, unless it is the preferred result itself, which nothing can
+	// beat. This trades up to Grace of latency, in runs where another
+	// request succeeds first, for the preferred result.
+	PreferAttempt int
+
+	// Clock, if set, tells the time in place of the time package, e.g. to
+	// drive hedges deterministically in tests. It paces hedges and measures
+	// durations; Timeout still runs on real time.
+	Clock Clock
+
+	// ReapTimeout, if positive, bounds how long to wait, after returning, for
+	// losing requests to complete, so that requests ignoring cancellation
+	// can't pile up waiting goroutines. The results of those completing later
+	// are dropped without being discarded, though still cleaned up. Zero
+	// waits as long as it takes.
+	ReapTimeout time.Duration
+
+	// Tracer, if set, traces each request in its own span, e.g. as a child
+	// of the caller's span.
+	Tracer Tracer
+
+	// Transform, if set, derives the context of each request from the one
+	// it would get, with its index, e.g. to stash a host or shard hint for
+	// that attempt that the request reads back. The context it is given
+	// already carries the index, see AttemptFromContext, and is cancelled
+	// as usual; the one it returns must derive from it. Calls come from the
+	// run's own goroutine, in send order, right before each request is sent.
+	Transform func(ctx context.Context, attempt int) context.Context
+
+	// Annotate, if set, replaces the value of a winner that succeeded with
+	// what it returns given that value and the winner's index, e.g. to wrap
+	// it in a struct carrying the index, keeping provenance without
+	// RunIndexed. Nil returns the value as is.
+	Annotate func(v interface{}, attempt int) interface{}
+
+	// Logger, if set, logs the run at debug level: each request sent or
+	// hedge skipped, with its delay since the run started, each request
+	// that lost before the run returned, and the outcome. Records carry the
+	// attempt and its delay or duration, and an error if any.
+	Logger *slog.Logger
+
+	// all makes every result lose, see RunAll.
+	all bool
+
+	// observe, if set, is called with every result received.
+	observe func(result)
+
+	// stats, if set, is filled in for RunStats.
+	stats *Stats
+
+	// name is the Name of the Hedger running the request, if any.
+	name string
+
+	// keepWinner leaves the winner's context alive until result.release is
+	// called.
+	keepWinner bool
+
+	// losers, if set, receives every result that didn't win, in place of
+	// Discard, and is closed once there are no more.
+	losers chan Result
+
+	// done, if set, is closed once there are no more results to receive.
+	done chan struct{}
+}
+
+// Limiter limits the rate of hedge requests.
+type Limiter interface {
+	// Allow reports whether a hedge may be sent now.
+	Allow() bool
+}
+
+// allowHedge reports whether a hedge may be sent now, within ctx, elapsed
+// into the run.
+func (o *Options) allowHedge(ctx context.Context, elapsed time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && (o.MinUseful > 0 || o.DeadlineMargin > 0) {
+		// A hedge gets what is left of the deadline, less what DeadlineMargin
+		// takes off.
+		left := time.Until(deadline)
+		if o.DeadlineMargin > 0 {
+			left -= elapsed + o.DeadlineMargin
+		}
+		if left <= 0 || left < o.MinUseful {
+			return false
+		}
+	}
+	if o.ShouldHedge != nil && !o.ShouldHedge() {
+		return false
+	}
+	if o.Budget != nil && !o.Budget.Allow() {
+		return false
+	}
+	if o.Limiter != nil && !o.Limiter.Allow() {
+		// The hedge isn't sent after all.
+		if o.Budget != nil {
+			o.Budget.Return()
+		}
+		return false
+	}
+	return true
+}
+
+// ErrSuppressHedge may be returned by a request to stop any further hedges
+// from being sent, e.g. once it knows its backend will be slow but succeed.
+// Requests already in flight are still waited on. A request returning it
+// doesn't win, unless every request loses.
+var ErrSuppressHedge = errors.New("hedged: suppress hedge")
+
+// DefaultMaxLoad is the load above which Options.Gauge suppresses hedges when
+// Options.MaxLoad is zero.
+const DefaultMaxLoad = 0.8
+
+// overloaded reports whether Gauge tells a load above MaxLoad.
+func (o *Options) overloaded() bool {
+	if o.Gauge == nil {
+		return false
+	}
+	max := o.MaxLoad
+	if max == 0 {
+		max = DefaultMaxLoad
+	}
+	return o.Gauge() > max
+}
+
+// loses reports whether res can't win.
+func (o *Options) loses(res result) bool {
+	if _, ok := res.Err.(PanicError); ok {
+		return true
+	}
+	if errors.Is(res.Err, ErrSuppressHedge) {
+		return true
+	}
+	if o.retries(res) || res.unacceptable {
+		return true
+	}
+	return o.all || o.firstSuccess && res.Err != nil
+}
+
+// Tracer starts spans for requests. It is meant to adapt a tracing library,
+// like OpenTelemetry, without this package depending on it.
+type Tracer interface {
+	// Start starts a span for the request with the given index, returning
+	// the context carrying the span to pass to the request.
+	Start(ctx context.Context, attempt int) (context.Context, Span)
+}
+
+// Span traces a request.
+type Span interface {
+	// End ends the span once the outcome of the request is known, with its
+	// error and whether it won, e.g. to set the attribute hedge.winner.
+	End(err error, winner bool)
+}
+
+// Clock tells the time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives the time once d elapses.
+	After(d time.Duration) <-chan time.Time
+}
+
+// now returns the current time by Clock, if set.
+func (o *Options) now() time.Time {
+	if o.Clock != nil {
+		return o.Clock.Now()
+	}
+	return time.Now()
+}
+
+// retries reports whether res lost in a way that calls for the next hedge
+// right away: Validate rejected it, AttemptTimeout elapsed, or it failed with
+// an error RetryOn matches.
+func (o *Options) retries(res result) bool {
+	if res.invalid || res.expired {
+		return true
+	}
+	return o.RetryOn != nil && res.Err != nil && o.RetryOn(res.Err)
+}
+
+// ErrTimeout is matched by a *TimeoutError, with errors.Is.
+var ErrTimeout = errors.New("hedged: timeout")
+
+// TimeoutError is returned once Options.Timeout elapses. It matches both
+// ErrTimeout and context.DeadlineExceeded, with errors.Is.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("hedged: timed out after %v", e.Timeout)
+}
+
+// Is reports whether target is ErrTimeout or context.DeadlineExceeded.
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout || target == context.DeadlineExceeded
+}
+
+// ErrHedgeTimeout is the cause, as reported by context.Cause, of a request's
+// context being done once Options.AttemptTimeout elapses, or once the
+// deadline of a hedge, shortened by Options.DeadlineMargin, passes.
+var ErrHedgeTimeout = errors.New("hedged: request timed out")
+
+// withTimeout bounds ctx by Timeout, if any.
+func (o *Options) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, o.Timeout, &TimeoutError{o.Timeout})
+}
+
+// timedOut returns the *TimeoutError ctx was cancelled with, if Timeout
+// elapsed, or nil.
+func timedOut(ctx context.Context) *TimeoutError {
+	var timeout *TimeoutError
+	if errors.As(context.Cause(ctx), &timeout) {
+		return timeout
+	}
+	return nil
+}
+
+// delay returns how long to wait before the hedge with the given index.
+func (o *Options) delay(attempt int, wait time.Duration) time.Duration {
+	d := wait
+	if o.Backoff != nil {
+		d = o.Backoff(attempt, wait)
+	}
+	if o.Jitter != nil {
+		d = o.Jitter(d)
+	}
+	return d
+}
+
+// ExponentialBackoff doubles the delay with every hedge: base before the
+// first, 2*base before the second, 4*base before the third, and so on. It is
+// meant for use as Options.Backoff.
+func ExponentialBackoff(attempt int, base time.Duration) time.Duration {
+	if attempt < 1 {
+		return base
+	}
+	return base << (attempt - 1)
+}
+
+// FullJitter returns a random duration in [0, base), for use as
+// Options.Jitter. It draws from generators of its own, seeded apart and
+// taken by one call at a time, rather than from the global generator of
+// math/rand, so that concurrent runs don't contend on a lock at high rates.
+func FullJitter(base time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	r := jitterRands.Get().(*rand.Rand)
+	d := time.Duration(r.Int63n(int64(base)))
+	jitterRands.Put(r)
+	return d
+}
+
+// jitterRands holds the generators of FullJitter.
+var jitterRands = sync.Pool{
+	New: func() interface{} { return rand.New(newSource()) },
+}
+
+// newSource returns a source seeded from the time, and apart from the others.
+func newSource() rand.Source {
+	return rand.NewSource(time.Now().UnixNano() ^ rand.Int63())
+}
+
+// NewFullJitter returns a jitter like FullJitter, but drawing from its own
+// generator seeded by src, e.g. to make the jitter reproducible in tests. A
+// nil src seeds it from the time. It is safe for concurrent use, behind a
+// lock of its own.
+func NewFullJitter(src rand.Source) func(base time.Duration) time.Duration {
+	if src == nil {
+		src = newSource()
+	}
+	var mu sync.Mutex
+	r := rand.New(src)
+	return func(base time.Duration) time.Duration {
+		if base <= 0 {
+			return 0
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return time.Duration(r.Int63n(int64(base)))
+	}
+}
+
+// PreferAttempt returns a Prefer for Options.Prefer that picks the result of
+// the request with the given index, if it succeeded, over any other, and
+// otherwise keeps the first, as Options.PreferAttempt does, e.g. to build on
+// it in a Prefer of one's own. Set as Prefer, the run can't tell that nothing
+// beats the preferred result, so it waits out Grace even once that arrives;
+// set PreferAttempt instead to return right away.
+func PreferAttempt(attempt int) func(a, b Result) Result {
+	return func(a, b Result) Result {
+		return preferAttempt(attempt, a, b)
+	}
+}
+
+// preferAttempt returns b if it is a success of the request with the given
+// index and a isn't, and a otherwise.
+func preferAttempt(attempt int, a, b Result) Result {
+	if b.Attempt == attempt && b.Err == nil && (a.Attempt != attempt || a.Err != nil) {
+		return b
+	}
+	return a
+}
+
+// RunOptions is like RunNE but customized by opts.
+func RunOptions(ctx context.Context, wait time.Duration, n int, r Request, opts Options) (interface{}, error) {
+	res := run(ctx, wait, n, r, &opts)
+	return res.Value, res.Err
+}
+
+// ErrNoDeadline is returned by RunAuto when ctx has no deadline.
+var ErrNoDeadline = errors.New("hedged: no deadline")
+
+// RunAuto is like RunN but derives the wait from the deadline of ctx: it is
+// the given fraction of the time remaining when RunAuto is called. If ctx has
+// no deadline, nothing is sent and the result is ErrNoDeadline.
+func RunAuto(ctx context.Context, fraction float64, n int, r Request) interface{} {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ErrNoDeadline
+	}
+	wait := time.Duration(fraction * float64(time.Until(deadline)))
+	if wait < 0 {
+		wait = 0
+	}
+	return RunN(ctx, wait, n, r)
+}
+
+// RunStreaming is like RunResult but leaves the winner's context alive, so
+// that its value may keep using it, e.g. to stream an *http.Response body. The
+// context of every other request is cancelled by the time RunStreaming
+// returns. The winner's is cancelled when the caller's ctx is, or when release
+// is called, which the caller must do once done with the value.
+func RunStreaming(ctx context.Context, wait time.Duration, n int, r Request) (res Result, release func()) {
+	out := run(ctx, wait, n, r, &Options{keepWinner: true})
+	if out.release == nil {
+		return out.Result, func() {}
+	}
+	return out.Result, out.release
+}
+
+// RunWithLosers is like RunResult but also returns the results of the
+// requests that completed after the winner, e.g. for read-repair, or after ctx
+// was done if none won. The losers channel receives each as it arrives, and is
+// closed once every request has returned, or ReapTimeout elapses.
+//
+// Reading losers is optional: it has room for every result, so that the run
+// can't block on it, but the results left in it are otherwise never
+// released.
+func RunWithLosers(ctx context.Context, wait time.Duration, n int, r Request) (winner Result, losers <-chan Result) {
+	ch := make(chan Result, clampN(n)+1)
+	res := run(ctx, wait, n, r, &Options{losers: ch})
+	return res.Result, ch
+}
+
+// RunWithDone is like RunN but also returns a channel that is closed once
+// every request has returned, losers included, e.g. to tear down resources
+// they share on shutdown, or in tests.
+func RunWithDone(ctx context.Context, wait time.Duration, n int, r Request) (interface{}, <-chan struct{}) {
+	done := make(chan struct{})
+	res := run(ctx, wait, n, r, &Options{done: done})
+	return res.value(), done
+}
+
+// RunIndexed is like RunN but also returns the index of the winning request,
+// in the order requests were sent: 0 is the original, 1 the first hedge, and so
+// on. The index is -1 if ctx is done before any request completes.
+func RunIndexed(ctx context.Context, wait time.Duration, n int, r Request) (interface{}, int) {
+	res := RunResult(ctx, wait, n, r)
+	return res.value(), res.Attempt
+}
+
+// RunFirstSuccess is like RunN but a request returning an error doesn't win:
+// the first request to succeed does, so that a fast failure can't beat a slow
+// success. Only if every request fails is an error returned: an Errors holding
+// the error of each request.
+//
+// The earliest request to succeed wins as soon as it does, the original or
+// not, and the others are cancelled at once. Unlike Options.Grace and
+// Options.Prefer, nothing waits for a better result, nor breaks ties between
+// results landing together.
+func RunFirstSuccess(ctx context.Context, wait time.Duration, n int, r Request) interface{} {
+	return run(ctx, wait, n, r, &Options{firstSuccess: true}).value()
+}
+
+// RunWithFallback is like RunFirstSuccess, hedging primary, but if every
+// request fails it calls fallback, e.g. to serve from a cache, and returns its
+// value and error instead. The fallback gets ctx, rather than the cancelled
+// context of any request. If ctx is done before any request succeeds, the
+// fallback isn't called and the error is ctx.Err().
+func RunWithFallback(ctx context.Context, wait time.Duration, n int, primary, fallback Request) (interface{}, error) {
+	res := run(ctx, wait, n, primary, &Options{firstSuccess: true})
+	if res.Err == nil || ctx.Err() != nil {
+		return res.Value, res.Err
+	}
+	return call(ctx, fallback)
+}
+
+// Stats describe a run.
+type Stats struct {
+	// Sent is the number of requests sent, the original included.
+	Sent int
+
+	// WinningAttempt is the index of the winning request, or -1 if none won.
+	WinningAttempt int
+
+	// Elapsed is how long the run took.
+	Elapsed time.Duration
+
+	// Durations holds the duration of each request by index, for those that
+	// completed before the run returned; it is zero for the rest, which were
+	// cancelled.
+	Durations []time.Duration
+
+	// Delays holds, for each hedge sent, how long after the previous request
+	// it was sent: the wait, as changed by Options.Jitter and
+	// Options.Backoff, or cut short by Options.RetryOn, or held back by
+	// Options.MaxInFlight.
+	Delays []time.Duration
+
+	// AttemptErrors holds the error of each request by index, for those that
+	// completed before the run returned, e.g. to tell which backend failed
+	// even though another won. It is nil for those that succeeded, and for
+	// the rest, whose errors come too late to be included.
+	AttemptErrors []error
+}
+
+// RunStats is like RunN but also describes the run, e.g. to tune the wait.
+func RunStats(ctx context.Context, wait time.Duration, n int, r Request) (interface{}, Stats) {
+	var stats Stats
+	res := run(ctx, wait, n, r, &Options{stats: &stats})
+	return res.value(), stats
+}
+
+// RunOptionsStats is like RunOptions but also describes the run, as RunStats
+// does, e.g. to check that Jitter and Backoff space hedges as expected.
+func RunOptionsStats(ctx context.Context, wait time.Duration, n int, r Request, opts Options) (interface{}, Stats, error) {
+	var stats Stats
+	opts.stats = &stats
+	res := run(ctx, wait, n, r, &opts)
+	return res.Value, stats, res.Err
+}
+
+// RunAll is like RunN but sends every request, at the same intervals, without
+// picking a winner, e.g. for scatter-gather. The result of each is sent on the
+// returned channel as it completes, which is closed once all have, or when ctx
+// is done, cancelling those in flight.
+//
+// A consumer may stop reading at any time without blocking the run, but the
+// requests left keep running until ctx is done, so one that stops early
+// should cancel ctx, e.g. with DrainAndCancel.
+func RunAll(ctx context.Context, wait time.Duration, n int, r Request) <-chan Result {
+	// Room for every result, so that a consumer that stops reading can't
+	// block the run.
+	out := make(chan Result, clampN(n)+1)
+	go func() {
+		defer close(out)
+		run(ctx, wait, n, r, &Options{
+			all:     true,
+			observe: func(res result) { out <- res.Result },
+		})
+	}()
+	return out
+}
+
+// RunAllOrdered is like RunAll but sends the results on the returned channel
+// in send order rather than completion order: attempt 0 first, then the first
+// hedge, and so on. A result completing before those of requests sent earlier
+// is held until they have been sent on, so it is seen no sooner than the
+// slowest of them, at the cost of that added latency.
+//
+// If ctx is done first, the requests in flight are cancelled, and those
+// returning by then are sent on as usual. The results still held are then
+// sent on in order, skipping the requests that never returned, before the
+// channel is closed.
+func RunAllOrdered(ctx context.Context, wait time.Duration, n int, r Request) <-chan Result {
+	// Room for every result, as for RunAll.
+	out := make(chan Result, clampN(n)+1)
+	go func() {
+		defer close(out)
+		held := make(map[int]Result)
+		next := 0
+		run(ctx, wait, n, r, &Options{
+			all: true,
+			observe: func(res result) {
+				held[res.Attempt] = res.Result
+				for res, ok := held[next]; ok; res, ok = held[next] {
+					delete(held, next)
+					out <- res
+					next++
+				}
+			},
+		})
+		for attempt := next; len(held) > 0; attempt++ {
+			if res, ok := held[attempt]; ok {
+				delete(held, attempt)
+				out <- res
+			}
+		}
+	}()
+	return out
+}
+
+// DrainAndCancel cancels the rest of a RunAll, with the cancel of its ctx, and
+// waits for ch to be closed, discarding what remains. Once it returns, the run
+// has ended; requests yet to return are reaped as usual.
+func DrainAndCancel(cancel context.CancelFunc, ch <-chan Result) {
+	cancel()
+	for range ch {
+	}
+}
+
+// RunAllInto is like RunAll but waits for every request, appending the
+// result of each to buf, which it returns. Results are in completion order,
+// not send order. If ctx is done first, only the results received by then are
+// appended.
+//
+// Reusing buf across calls avoids allocating the results, e.g. on a hot path:
+//
+//	buf = hedged.RunAllInto(ctx, wait, n, r, buf[:0])
+func RunAllInto(ctx context.Context, wait time.Duration, n int, r Request, buf []Result) []Result {
+	run(ctx, wait, n, r, &Options{
+		all:     true,
+		observe: func(res result) { buf = append(buf, res.Result) },
+	})
+	return buf
+}
+
+// ErrNoValidResult is returned, with the last value rejected, when
+// Options.Validate rejects every value.
+var ErrNoValidResult = errors.New("hedged: no valid result")
+
+// ErrNoAcceptableResult is returned, with the last value not accepted, when
+// Options.Acceptable accepts no result.
+var ErrNoAcceptableResult = errors.New("hedged: no acceptable result")
+
+// ErrWinnerChosen is the cause, as reported by context.Cause, with which the
+// context of a request is cancelled when another request won. A request
+// cancelled because ctx was done reports the cause of ctx instead.
+var ErrWinnerChosen = errors.New("hedged: winner chosen")
+
+// ErrFailFast is the cause, as reported by context.Cause, with which the
+// context of a request is cancelled once Options.FailFastQuorum requests have
+// failed.
+var ErrFailFast = errors.New("hedged: too many requests failed")
+
+// ErrCallerCancelled is returned by Reason for the context of a request
+// cancelled because the caller's ctx was done. The caller's own cause is
+// still reported by context.Cause.
+var ErrCallerCancelled = errors.New("hedged: caller cancelled")
+
+// Reason returns why the context of a request, as passed to Req, was
+// cancelled: ErrWinnerChosen if another request won, ErrFailFast if too many
+// failed, ErrHedgeTimeout if its own time ran out, a *TimeoutError if
+// Options.Timeout elapsed, or ErrCallerCancelled if the caller's ctx was
+// done, e.g. so that a request can tell whether to log its failure. It
+// returns nil if ctx isn't done, or didn't come from this package.
+func Reason(ctx context.Context) error {
+	if _, ok := AttemptFromContext(ctx); !ok || ctx.Err() == nil {
+		return nil
+	}
+	cause := context.Cause(ctx)
+	var timeout *TimeoutError
+	switch {
+	case cause == ErrWinnerChosen, cause == ErrFailFast, cause == ErrHedgeTimeout:
+		return cause
+	case errors.As(cause, &timeout):
+		return timeout
+	}
+	return ErrCallerCancelled
+}
 
-	newCtx, done := context.WithCancel(ctx)
-	ch := make(chan interface{}, n)
-	sent := 0
+// maxBuffer caps the buffer for results.
+const maxBuffer = 8
+
+// hedge is the state of a single run.
+type hedge struct {
+	o    *Options
+	r    Request
+	ctx  context.Context
+	wait time.Duration
+	n    int
+	// start is when the run started, for Options.Logger.
+	start time.Time
+	// realStart is start by the time package, which deadlines run on,
+	// rather than by Options.Clock.
+	realStart time.Time
+
+	// Results wait here to be received by the run, or by the reaper once it
+	// returns. The buffer is small, even for large n, since few requests are
+	// usually sent. It is never closed: the reaper counts the results it
+	// is owed instead, so a late send can't panic.
+	ch chan result
+	// hedgeNow receives once a request calls Hedge.
+	hedgeNow chan struct{}
+	// abandoned is closed once the reaper stops receiving, after
+	// Options.ReapTimeout, so that later requests don't block on sending.
+	abandoned chan struct{}
+	// Each request gets its own context, so that the winner's can outlive the
+	// others when asked to.
+	cancels []context.CancelCauseFunc
+	// cancelsBuf backs cancels for the usual few requests, sparing an
+	// allocation.
+	cancelsBuf [2]context.CancelCauseFunc
+
+	// Each hedge either gets sent or, if denied, skipped; both count towards
+	// n.
+	sent, skipped, received int
+
+	errs Errors
+	// failed counts the results lost to an error, for
+	// Options.FailFastQuorum.
+	failed    int
+	durations []time.Duration
+	// attemptErrs has the error of each result received, for Stats.
+	attemptErrs []error
+	// delays are between sends, from lastSent, for Stats.
+	delays   []time.Duration
+	lastSent time.Time
+
+	// rejected is the last result Options.Validate rejected, held on to in
+	// case none is valid.
+	rejected *result
+
+	// When the winner and the last request completed, for Options.OnSavings.
+	won, latest time.Time
+
+	// stopLosers cancels the losers left running by Options.KeepLosers.
+	stopLosers func()
+
+	// A single timer paces the hedges, rather than a new one per iteration.
+	timer *time.Timer
+}
+
+// after returns a channel that receives once d elapses, reusing the timer
+// unless Options.Clock is set. Only one may be pending at a time.
+func (h *hedge) after(d time.Duration) <-chan time.Time {
+	if h.o.Clock != nil {
+		return h.o.Clock.After(d)
+	}
+	if h.timer == nil {
+		if t, ok := timers.Get().(*time.Timer); ok {
+			t.Reset(d)
+			h.timer = t
+		} else {
+			h.timer = time.NewTimer(d)
+		}
+	} else {
+		h.timer.Reset(d)
+	}
+	return h.timer.C
+}
+
+// timers holds stopped and drained timers for reuse across runs, so that a
+// run whose first request beats the wait allocates none.
+var timers sync.Pool
+
+// stopTimer stops the timer, draining a tick it may have sent, so that it can
+// be reused before it fires.
+func (h *hedge) stopTimer() {
+	if h.timer != nil && !h.timer.Stop() {
+		select {
+		case <-h.timer.C:
+		default:
+		}
+	}
+}
+
+// more reports whether any requests are left to send.
+func (h *hedge) more() bool {
+	return h.sent+h.skipped <= h.n
+}
+
+// send sends the next request, unless it is a hedge that is denied.
+func (h *hedge) send() {
+	o := h.o
+	if h.sent > 0 && o.overloaded() {
+		h.log("hedged: hedges suppressed by load", slog.Int("attempt", h.sent+h.skipped), slog.Duration("delay", o.now().Sub(h.start)))
+		h.skipped = h.n + 1 - h.sent
+		return
+	}
+	elapsed := time.Since(h.realStart)
+	if h.sent > 0 && !o.allowHedge(h.ctx, elapsed) {
+		h.log("hedged: hedge skipped", slog.Int("attempt", h.sent+h.skipped), slog.Duration("delay", o.now().Sub(h.start)))
+		h.skipped++
+		return
+	}
+	if o.stats != nil {
+		now := o.now()
+		if h.sent > 0 {
+			h.delays = append(h.delays, now.Sub(h.lastSent))
+		}
+		h.lastSent = now
+	}
+	// The scheduler may run goroutines out of the definition order. We
+	// count outside the goroutine to guarantee it happens here, specifically,
+	// before the reaper further below reads how many results are outstanding.
+	attempt := h.sent
+	h.sent++
+	if o.OnSend != nil {
+		o.OnSend(attempt)
+	}
+	h.log("hedged: request sent", slog.Int("attempt", attempt), slog.Duration("delay", o.now().Sub(h.start)))
+	// Even with no hedges, the request's context is cancelled once the run
+	// returns, as documented on Request.
+	ctx, cancel := context.WithCancelCause(h.ctx)
+	if deadline, ok := ctx.Deadline(); ok && attempt > 0 && o.DeadlineMargin > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithDeadlineCause(ctx, deadline.Add(-elapsed-o.DeadlineMargin), ErrHedgeTimeout)
+		parent := cancel
+		cancel = func(cause error) { parent(cause); stop() }
+	}
+	if o.AttemptTimeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, o.AttemptTimeout, ErrHedgeTimeout)
+		parent := cancel
+		cancel = func(cause error) { parent(cause); stop() }
+	}
+	h.cancels = append(h.cancels, cancel)
+	ctx = &attemptCtx{ctx, attempt, h}
+	if o.Transform != nil {
+		ctx = o.Transform(ctx, attempt)
+	}
+	go func() {
+		var span Span
+		if o.Tracer != nil {
+			ctx, span = o.Tracer.Start(ctx, attempt)
+		}
+		start := o.now()
+		v, err := call(ctx, h.r)
+		if attempt > 0 && o.Budget != nil {
+			o.Budget.Return()
+		}
+		done := o.now()
+		d := done.Sub(start)
+		invalid := err == nil && o.Validate != nil && !o.Validate(v)
+		unacceptable := !invalid && o.Acceptable != nil && !o.Acceptable(v, err)
+		expired := context.Cause(ctx) == ErrHedgeTimeout
+		if o.OnComplete != nil {
+			o.OnComplete(attempt, err, d)
+		}
+		// Every request sends exactly one result, which the reaper counts
+		// on, unless abandoned. A cancelled request still sends, rather than
+		// giving up on ctx.Done, so its value gets discarded.
+		res := result{Result: Result{v, err, attempt}, d: d, done: done, invalid: invalid, unacceptable: unacceptable, expired: expired, span: span}
+		select {
+		case h.ch <- res:
+			// Sent just as the reaper gave up: clean up the results left
+			// behind, for want of a reaper to.
+			select {
+			case <-h.abandoned:
+				h.drain()
+			default:
+			}
+		case <-h.abandoned:
+			h.cleanup(res)
+		}
+	}()
+}
+
+// receive accounts for a result received from a request, and reports whether
+// it lost.
+func (h *hedge) receive(res result) bool {
+	o := h.o
+	h.received++
+	h.track(res)
+	if o.stats != nil {
+		for len(h.durations) <= res.Attempt {
+			h.durations = append(h.durations, 0)
+			h.attemptErrs = append(h.attemptErrs, nil)
+		}
+		h.durations[res.Attempt] = res.d
+		h.attemptErrs[res.Attempt] = res.Err
+	}
+	if o.observe != nil {
+		o.observe(res)
+	}
+	// The request asked for no more hedges: skip the rest.
+	if errors.Is(res.Err, ErrSuppressHedge) {
+		h.skipped = h.n + 1 - h.sent
+	}
+	if !o.loses(res) {
+		return false
+	}
+	h.log("hedged: request lost", slog.Int("attempt", res.Attempt), slog.Duration("duration", res.d), slog.Any("error", res.Err))
+	// Rejected results are held on to by reject, and ended and cleaned up
+	// from there.
+	if !res.rejected() {
+		res.end(false)
+		h.cleanup(res)
+	}
+	for len(h.errs) <= res.Attempt {
+		h.errs = append(h.errs, nil)
+	}
+	h.errs[res.Attempt] = res.Err
+	if res.Err != nil {
+		h.failed++
+	}
+	return true
+}
+
+// failedFast reports whether Options.FailFastQuorum requests have failed.
+func (h *hedge) failedFast() bool {
+	return h.o.FailFastQuorum > 0 && h.failed >= h.o.FailFastQuorum
+}
+
+// discard hands a result that didn't win to Options.Discard, and closes it if
+// Options.AutoCloseLosers is set, unless it is streamed to the losers instead.
+func (h *hedge) discard(res result) {
+	res.end(false)
+	if h.o.losers != nil {
+		// Never blocks: losers has room for every result.
+		h.o.losers <- res.Result
+		return
+	}
+	defer h.cleanup(res)
+	if res.Err != nil {
+		return
+	}
+	if h.o.Discard != nil {
+		h.o.Discard(res.Value)
+	}
+	if c, ok := res.Value.(io.Closer); ok && h.o.AutoCloseLosers {
+		c.Close()
+	}
+}
+
+// log logs msg at debug level to Options.Logger, if set.
+func (h *hedge) log(msg string, attrs ...slog.Attr) {
+	if h.o.Logger != nil {
+		if h.o.name != "" {
+			attrs = append(attrs, slog.String("hedger", h.o.name))
+		}
+		h.o.Logger.LogAttrs(h.ctx, slog.LevelDebug, msg, attrs...)
+	}
+}
+
+// cleanup calls Options.Cleanup, if set, with res.
+func (h *hedge) cleanup(res result) {
+	if h.o.Cleanup != nil {
+		h.o.Cleanup(res.Attempt, res.Value, res.Err)
+	}
+}
+
+// clampN treats a negative number of hedges as 0.
+func clampN(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func run(ctx context.Context, wait time.Duration, n int, r Request, o *Options) result {
+	var res result
+	start := o.now()
+	n = clampN(n)
+
+	ctx, stop := o.withTimeout(ctx)
+	buffer := n + 1
+	if buffer > maxBuffer {
+		buffer = maxBuffer
+	}
+	h := &hedge{
+		o:         o,
+		r:         r,
+		ctx:       ctx,
+		wait:      wait,
+		n:         n,
+		ch:        make(chan result, buffer),
+		hedgeNow:  make(chan struct{}, 1),
+		start:     start,
+		realStart: start,
+	}
+	h.cancels = h.cancelsBuf[:0]
+	if o.Clock != nil {
+		h.realStart = time.Now()
+	}
+	if o.ReapTimeout > 0 {
+		h.abandoned = make(chan struct{})
+	}
+	// A context cancelled already sends nothing: the run returns its error.
+	next := ctx.Err() == nil
+	// The tick is nil unless a request is pending.
+	var tick <-chan time.Time
+	if next && o.InitialDelay > 0 {
+		next, tick = false, h.after(o.InitialDelay)
+	}
 
 	for {
-		if sent <= n {
-			sent++
-			// The scheduler may run goroutines out of the definition order. We
-			// increment outside the goroutine to guarantee it happens here,
-			// specifically, before the call to wg.Wait further below.
-			wg.Add(1)
-			go func() {
-				res, err := r.Req(newCtx)
-				if err != nil {
-					ch <- err
+		if next && h.more() && (o.MaxInFlight <= 0 || h.sent-h.received < o.MaxInFlight) {
+			next = false
+			h.send()
+			if h.more() {
+				// No delay sends the next hedge right away, before looking
+				// at any result.
+				if d := o.delay(h.sent+h.skipped, wait); d > 0 {
+					tick = h.after(d)
 				} else {
-					ch <- res
+					next = true
+					continue
 				}
-				// Calling Done implies that this thread has no further use for the
-				// chan (i.e. won't write to it). When every thread signals this, then
-				// parent thread may close it safely.
-				wg.Done()
-			}()
+			}
 		}
 
 		// Proceed with whichever one is ready first:
 		// 1. One of the requests has finished processing;
 		// 2. Caller cancelled the context;
-		// 3. Time to issue a hedged request.
+		// 3. Time to issue the next request.
 		select {
-		case v = <-ch:
-			goto Done
+		case res = <-h.ch:
 		case <-ctx.Done():
-			v = ctx.Err()
+			res = result{Result: Result{nil, ctx.Err(), -1}}
+			// A result may have landed at the same time: prefer it over the
+			// error.
+			for {
+				select {
+				case other := <-h.ch:
+					if !h.receive(other) {
+						res = other
+						goto Done
+					}
+					h.reject(other)
+					continue
+				default:
+				}
+				break
+			}
 			goto Done
-		case <-time.After(wait):
+		case <-h.hedgeNow:
+			// A request asked for the next hedge: send it now, if due
+			// at all.
+			if tick != nil {
+				h.stopTimer()
+				next, tick = true, nil
+			}
 			continue
+		case <-tick:
+			next, tick = true, nil
+			// A result that landed meanwhile may make the hedge needless.
+			select {
+			case res = <-h.ch:
+			default:
+				continue
+			}
 		}
+
+		// A panic never wins, nor does an error in first-success mode, unless
+		// every request has lost.
+		if h.receive(res) {
+			if !h.more() {
+				next, tick = false, nil
+			} else if o.retries(res) {
+				// Send the next hedge right away.
+				h.stopTimer()
+				next, tick = true, nil
+			}
+			h.reject(res)
+			if h.failedFast() {
+				res = result{Result: Result{nil, h.errs, -1}}
+				goto Done
+			}
+			if h.received < h.sent || h.more() {
+				continue
+			}
+			if h.rejected != nil {
+				err := ErrNoValidResult
+				if h.rejected.unacceptable {
+					err = ErrNoAcceptableResult
+					if h.rejected.Err != nil {
+						err = fmt.Errorf("%w: %w", ErrNoAcceptableResult, h.rejected.Err)
+					}
+				}
+				res = result{Result: Result{h.rejected.Value, err, -1}}
+				h.rejected.end(false)
+				h.cleanup(*h.rejected)
+				h.rejected = nil
+			} else if o.firstSuccess {
+				res = result{Result: Result{nil, h.errs, -1}}
+			} else {
+				res = result{Result: Result{nil, res.Err, -1}}
+			}
+			goto Done
+		}
+		if o.Grace > 0 || o.Prefer != nil {
+			res = h.grace(res)
+		}
+		goto Done
 	}
 
 Done:
-	// Cancel the slower requests and wait for threads to acknowledge
-	// cancellation before closing the channel.
-	done()
-	go func() { wg.Wait(); close(ch) }()
+	if h.rejected != nil {
+		h.discard(*h.rejected)
+	}
+	// However it got here, a deadline from Timeout is reported as such.
+	if res.Err == context.DeadlineExceeded {
+		if timeout := timedOut(ctx); timeout != nil {
+			res.Err = timeout
+		}
+	}
+	if h.timer != nil {
+		h.stopTimer()
+		timers.Put(h.timer)
+	}
+	res.end(true)
+	// Cancel the slower requests, unless kept until reaped.
+	cause := ErrWinnerChosen
+	if ctx.Err() != nil {
+		cause = context.Cause(ctx)
+	} else if h.failedFast() {
+		cause = ErrFailFast
+	}
+	keep := o.KeepLosers && ctx.Err() == nil
+	var kept []context.CancelCauseFunc
+	for i, cancel := range h.cancels {
+		if o.keepWinner && i == res.Attempt {
+			res.release = func() { cancel(nil); stop() }
+			continue
+		}
+		if keep && i != res.Attempt {
+			kept = append(kept, cancel)
+			continue
+		}
+		cancel(cause)
+	}
+	if keep {
+		h.stopLosers = func() {
+			for _, cancel := range kept {
+				cancel(cause)
+			}
+			stop()
+		}
+	} else if res.release == nil {
+		stop()
+	}
+	if o.stats != nil {
+		durations := make([]time.Duration, h.sent)
+		copy(durations, h.durations)
+		attemptErrs := make([]error, h.sent)
+		copy(attemptErrs, h.attemptErrs)
+		*o.stats = Stats{
+			Sent:           h.sent,
+			WinningAttempt: res.Attempt,
+			Elapsed:        o.now().Sub(start),
+			Durations:      durations,
+			Delays:         h.delays,
+			AttemptErrors:  attemptErrs,
+		}
+	}
+	if res.Attempt >= 0 {
+		h.won = res.done
+		h.log("hedged: request won", slog.Int("attempt", res.Attempt), slog.Duration("duration", res.d), slog.Any("error", res.Err))
+		h.cleanup(res)
+	} else {
+		h.log("hedged: no request won", slog.Int("sent", h.sent), slog.Any("error", res.Err))
+	}
+	if o.Annotate != nil && res.Attempt >= 0 && res.Err == nil {
+		res.Value = o.Annotate(res.Value, res.Attempt)
+	}
+	// Reap the outstanding requests, if any: whatever they send lost.
+	if outstanding := h.sent - h.received; outstanding > 0 {
+		atomic.AddInt64(&reapers, 1)
+		go h.reap(outstanding)
+	} else {
+		h.reaped()
+	}
+
+	return res
+}
+
+// reapers counts the reapers running, see Reapers.
+var reapers int64
 
-	return v
+// Reapers returns the number of runs, across the package, that have returned
+// but are still waiting for losing requests to complete. A count that keeps
+// growing points at requests that ignore cancellation; see
+// Options.ReapTimeout.
+func Reapers() int {
+	return int(atomic.LoadInt64(&reapers))
+}
+
+// reap receives the given number of outstanding results, discarding them,
+// unless Options.ReapTimeout elapses first.
+func (h *hedge) reap(outstanding int) {
+	defer atomic.AddInt64(&reapers, -1)
+	defer h.reaped()
+	var expired <-chan time.Time
+	if h.o.ReapTimeout > 0 {
+		timer := time.NewTimer(h.o.ReapTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for ; outstanding > 0; outstanding-- {
+		select {
+		case res := <-h.ch:
+			h.track(res)
+			h.discard(res)
+		case <-expired:
+			close(h.abandoned)
+			h.drain()
+			return
+		}
+	}
+}
+
+// drain cleans up the results buffered once abandoned, which no one is left
+// to receive. Only Cleanup still applies to them.
+func (h *hedge) drain() {
+	for {
+		select {
+		case res := <-h.ch:
+			h.cleanup(res)
+		default:
+			return
+		}
+	}
+}
+
+// track notes when res completed, for Options.OnSavings.
+func (h *hedge) track(res result) {
+	if h.o.OnSavings != nil && res.done.After(h.latest) {
+		h.latest = res.done
+	}
+}
+
+// reject holds on to res if Options.Validate or Options.Acceptable rejected
+// it, discarding the result held before, or res if Options.Rank ranks it
+// lower.
+func (h *hedge) reject(res result) {
+	if !res.rejected() {
+		return
+	}
+	if h.rejected != nil {
+		if o := h.o; o.Rank != nil && res.unacceptable && h.rejected.unacceptable && o.Rank(res.Value, h.rejected.Value) <= 0 {
+			// The result held ranks at least as high.
+			h.discard(res)
+			return
+		}
+		h.discard(*h.rejected)
+	}
+	h.rejected = &res
+}
+
+// reaped is called once no more results are to be received.
+func (h *hedge) reaped() {
+	if h.stopLosers != nil {
+		h.stopLosers()
+	}
+	h.savings()
+	if h.o.losers != nil {
+		close(h.o.losers)
+	}
+	if h.o.done != nil {
+		close(h.o.done)
+	}
+}
+
+// savings calls Options.OnSavings, if set, for a run with a winner.
+func (h *hedge) savings() {
+	if h.o.OnSavings == nil || h.won.IsZero() {
+		return
+	}
+	h.o.OnSavings(h.latest.Sub(h.won), h.sent > 1)
+}
+
+// grace waits up to Options.Grace after the first result for a better one, as
+// judged by Options.Prefer, and returns the preferred result. No more hedges
+// are sent meanwhile. Without Grace, it only offers those already received.
+func (h *hedge) grace(res result) result {
+	o := h.o
+	if o.Grace <= 0 {
+		// Only offer the results already received.
+		for h.received < h.sent && !o.unbeatable(res) {
+			select {
+			case other := <-h.ch:
+				res = h.prefer(res, other)
+			default:
+				return res
+			}
+		}
+		return res
+	}
+	var expired <-chan time.Time
+	if o.Clock != nil {
+		expired = o.Clock.After(o.Grace)
+	} else {
+		timer := time.NewTimer(o.Grace)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for h.received < h.sent && !o.unbeatable(res) {
+		select {
+		case other := <-h.ch:
+			res = h.prefer(res, other)
+		case <-expired:
+			return res
+		case <-h.ctx.Done():
+			return res
+		}
+	}
+	return res
+}
+
+// prefersAttempt reports whether PreferAttempt picks between results.
+func (o *Options) prefersAttempt() bool {
+	return o.Prefer == nil && o.Grace > 0 && o.PreferAttempt >= 0
+}
+
+// unbeatable reports whether res is a success of the request PreferAttempt
+// picks over any other, so that there is no better result to wait for.
+func (o *Options) unbeatable(res result) bool {
+	return o.prefersAttempt() && res.Attempt == o.PreferAttempt && res.Err == nil
+}
+
+// prefer returns whichever of res and other Options.Prefer picks, discarding
+// the other. A losing other is never picked.
+func (h *hedge) prefer(res, other result) result {
+	o := h.o
+	if h.receive(other) {
+		// receive cleaned it up already, unless rejected.
+		if other.rejected() {
+			h.discard(other)
+		}
+		return res
+	}
+	picked := res.Result
+	if o.Prefer != nil {
+		picked = o.Prefer(res.Result, other.Result)
+	} else if o.prefersAttempt() {
+		picked = preferAttempt(o.PreferAttempt, res.Result, other.Result)
+	}
+	if picked.Attempt == other.Attempt {
+		res, other = other, res
+	}
+	h.discard(other)
+	return res
+}
+
+// call sends the request, recovering a panic as a PanicError.
+func call(ctx context.Context, r Request) (v interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = PanicError{Value: p, Stack: debug.Stack()}
+		}
+	}()
+	return r.Req(ctx)
+}
+
+// PanicError is the error of a request that panicked. It never wins, so that
+// other requests may still succeed, unless every request loses.
+type PanicError struct {
+	// Value is what the request panicked with.
+	Value interface{}
+
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e PanicError) Error() string {
+	return fmt.Sprintf("hedged: request panicked: %v", e.Value)
+}
+
+// attemptKey is the context key for the index of a request. Context keys of
+// this package are of unexported types, so that no key of a caller, whatever
+// its value, can collide with them.
+type attemptKey struct{}
+
+// hedgeKey is the context key for the run a request belongs to.
+type hedgeKey struct{}
+
+// attemptCtx is the context of a request, carrying its index and run, for
+// AttemptFromContext and Hedge, in one context rather than one per value.
+type attemptCtx struct {
+	context.Context
+	attempt int
+	h       *hedge
+}
+
+func (c *attemptCtx) Value(key interface{}) interface{} {
+	switch key.(type) {
+	case attemptKey:
+		return c.attempt
+	case hedgeKey:
+		return c.h
+	}
+	return c.Context.Value(key)
+}
+
+// Hedge, called by a request with the context passed to Req, sends the next
+// hedge of its run right away, rather than once the wait elapses, e.g. once
+// the request learns it will be slow from the depth of a queue. It does
+// nothing if no hedge is pending, the run is over, or ctx didn't come from
+// this package. The wait for the hedges after it starts over.
+func Hedge(ctx context.Context) {
+	h, ok := ctx.Value(hedgeKey{}).(*hedge)
+	if !ok {
+		return
+	}
+	select {
+	case h.hedgeNow <- struct{}{}:
+	default:
+	}
+}
+
+// NameFromContext returns the Name of the Hedger running the request ctx was
+// passed to, e.g. to label what a request, Options.Transform or a Tracer
+// records. It reports false if ctx didn't come from a Hedger with a name.
+func NameFromContext(ctx context.Context) (string, bool) {
+	h, ok := ctx.Value(hedgeKey{}).(*hedge)
+	if !ok || h.o.name == "" {
+		return "", false
+	}
+	return h.o.name, true
+}
+
+// AttemptFromContext returns the index of the request ctx was passed to, in
+// the order requests were sent: 0 for the original, 1 for the first hedge, and
+// so on. It reports false if ctx didn't come from this package.
+func AttemptFromContext(ctx context.Context) (int, bool) {
+	attempt, ok := ctx.Value(attemptKey{}).(int)
+	return attempt, ok
+}
+
+// Errors holds the error of each request, by index, when they all fail, or
+// enough of them for Options.FailFastQuorum. A request that didn't fail has a
+// nil error, which Error leaves out.
+type Errors []error
+
+func (e Errors) Error() string {
+	failed := 0
+	for _, err := range e {
+		if err != nil {
+			failed++
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "hedged: %d requests failed", failed)
+	for i, err := range e {
+		if err != nil {
+			fmt.Fprintf(&b, "; attempt %d: %v", i, err)
+		}
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors, for use with errors.Is and errors.As.
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Result is the outcome of a request.
+type Result struct {
+	// Value is what the request returned, if it succeeded.
+	Value interface{}
+
+	// Err is the error the request returned, if it failed.
+	Err error
+
+	// Attempt is the index of the request, in the order requests were sent: 0
+	// for the original, 1 for the first hedge, and so on. It is -1 if no
+	// request won, e.g. because ctx was done first.
+	Attempt int
+}
+
+// value returns Err if set, Value otherwise, as Run does.
+func (r Result) value() interface{} {
+	if r.Err != nil {
+		return r.Err
+	}
+	return r.Value
+}
+
+// result is what a single request returned.
+type result struct {
+	Result
+	d time.Duration
+	// done is when the request returned.
+	done time.Time
+	// invalid is set if Options.Validate rejected the value.
+	invalid bool
+	// unacceptable is set if Options.Acceptable rejected the result.
+	unacceptable bool
+	// expired is set if Options.AttemptTimeout elapsed, or the deadline
+	// shortened by Options.DeadlineMargin passed.
+	expired bool
+
+	// release cancels the winner's context, if kept alive by keepWinner.
+	release func()
+
+	// span traces the request, if Options.Tracer is set.
+	span Span
+}
+
+// rejected reports whether Options.Validate or Options.Acceptable rejected
+// res.
+func (res result) rejected() bool {
+	return res.invalid || res.unacceptable
+}
+
+// end ends the span of the request, if any.
+func (res result) end(winner bool) {
+	if res.span != nil {
+		res.span.End(res.Err, winner)
+	}
+}
+
+// RunTyped is like RunE but for a request function returning a concrete type,
+// sparing the caller a type assertion.
+//
+// As is idiomatic for a (T, error) result, a request returning an error
+// doesn't win: the first to succeed does, as with RunFirstSuccess. If both
+// requests fail, the result is the zero value of T and an Errors holding the
+// error of each; if ctx is done before any request succeeds, it is the zero
+// value of T and ctx.Err().
+func RunTyped[T any](ctx context.Context, wait time.Duration, r func(context.Context) (T, error)) (T, error) {
+	res := run(ctx, wait, 1, Func(r), &Options{firstSuccess: true})
+	t, _ := res.Value.(T)
+	return t, res.Err
 }
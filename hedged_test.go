@@ -1,7 +1,16 @@
 package hedged
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -59,14 +68,14 @@ func BenchmarkHedge(b *testing.B) {
 }
 
 type hungOdds struct {
-	i    int
 	done chan<- struct{}
 }
 
 func (h *hungOdds) Req(ctx context.Context) (interface{}, error) {
-	h.i++
-	if !Odd(h.i) {
-		return h.i, nil
+	attempt, _ := AttemptFromContext(ctx)
+	i := attempt + 1
+	if !Odd(i) {
+		return i, nil
 	}
 
 	select {
@@ -76,24 +85,124 @@ func (h *hungOdds) Req(ctx context.Context) (interface{}, error) {
 	}
 }
 
+// fakeClock is a Clock whose time only moves when told to.
+type fakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	c := &fakeClock{now: time.Unix(0, 0)}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, waiter{c.now.Add(d), ch})
+	c.cond.Broadcast()
+	return ch
+}
+
+// BlockUntil waits until n callers are waiting on After.
+func (c *fakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// Advance moves the time forward by d, firing any waiters that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
 func TestCancel(t *testing.T) {
 	ctx := context.TODO()
 	done := make(chan struct{})
-	h := &hungOdds{0, done}
-	switch v := Run(ctx, 10*time.Millisecond, h).(type) {
-	case int:
-		if Odd(v) {
-			t.Errorf("Expected even number, got %d", v)
-		}
+	h := &hungOdds{done}
+	clock := newFakeClock()
+	go func() {
+		// Fire the hedge once the original is waiting on it.
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Millisecond)
+	}()
+	// The run's done hook, as used by RunWithDone, is closed once every
+	// request has returned: the hung one must have been cancelled by then.
+	reaped := make(chan struct{})
+	v, _ := RunOptions(ctx, 10*time.Millisecond, 1, h, Options{Clock: clock, done: reaped})
+	if i, ok := v.(int); !ok || Odd(i) {
+		t.Errorf("Expected even number, got %v", v)
 	}
+	<-reaped
 	select {
 	case <-done:
-		break
-	case <-time.After(1 * time.Millisecond):
+	default:
 		t.Error("Hung request not cancelled")
 	}
 }
 
+func TestCancelWithoutHedges(t *testing.T) {
+	for _, n := range []int{0, 1} {
+		var reqCtx context.Context
+		RunN(context.TODO(), 1*time.Second, n, RequestFunc(func(ctx context.Context) (interface{}, error) {
+			reqCtx = ctx
+			return "howdy", nil
+		}))
+		if reqCtx.Err() == nil {
+			t.Errorf("Expected the winner's context cancelled with n = %d", n)
+		}
+	}
+}
+
+func TestCancelledBeforeRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	var calls int32
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "howdy", nil
+	})
+	if v := RunN(ctx, 0, 2, r); v != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", v)
+	}
+	v, done := RunWithDone(ctx, 0, 2, r)
+	if v != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", v)
+	}
+	<-done
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("Expected no request sent, got %d", n)
+	}
+}
+
 const ctxKey = 321
 
 type c struct{}
@@ -111,3 +220,2214 @@ func TestContext(t *testing.T) {
 		}
 	}
 }
+
+func TestContextKeys(t *testing.T) {
+	// Keys a caller might pick that equal the package's internal values.
+	keys := []interface{}{0, 1, "attempt", struct{}{}}
+	ctx := context.TODO()
+	for _, k := range keys {
+		ctx = context.WithValue(ctx, k, "caller")
+	}
+	var got []interface{}
+	attempt := -1
+	RunN(ctx, 10*time.Second, 0, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		for _, k := range keys {
+			got = append(got, ctx.Value(k))
+		}
+		attempt, _ = AttemptFromContext(ctx)
+		return nil, nil
+	}))
+	for i, v := range got {
+		if v != "caller" {
+			t.Errorf("Expected caller's value for %#v, got %v", keys[i], v)
+		}
+	}
+	if attempt != 0 {
+		t.Errorf("Expected attempt 0, got %d", attempt)
+	}
+	if _, ok := AttemptFromContext(ctx); ok {
+		t.Error("Expected no attempt in the caller's context")
+	}
+}
+
+var errHowdy = errors.New("howdy")
+
+func TestRunE(t *testing.T) {
+	ctx := context.TODO()
+	v, err := RunE(ctx, 10*time.Second, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		return errHowdy, nil
+	}))
+	if err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+	if v != errHowdy {
+		t.Errorf("Expected errHowdy value, got %v", v)
+	}
+
+	v, err = RunE(ctx, 10*time.Second, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		return nil, errHowdy
+	}))
+	if err != errHowdy {
+		t.Errorf("Expected errHowdy, got %v", err)
+	}
+	if v != nil {
+		t.Errorf("Expected nil value, got %v", v)
+	}
+}
+
+func TestRunTyped(t *testing.T) {
+	v, err := RunTyped(context.TODO(), 10*time.Second, func(ctx context.Context) (string, error) {
+		return "howdy", nil
+	})
+	if err != nil || v != "howdy" {
+		t.Errorf("Expected howdy, got %q, %v", v, err)
+	}
+}
+
+func TestRunTypedFailure(t *testing.T) {
+	// A fast failure doesn't beat a slower success.
+	v, err := RunTyped(context.TODO(), 1*time.Millisecond, func(ctx context.Context) (int, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			return 0, errHowdy
+		}
+		return 42, nil
+	})
+	if err != nil || v != 42 {
+		t.Errorf("Expected 42, got %v, %v", v, err)
+	}
+
+	v, err = RunTyped(context.TODO(), 1*time.Millisecond, func(ctx context.Context) (int, error) {
+		return -1, errHowdy
+	})
+	if errs, ok := err.(Errors); !ok || len(errs) != 2 || errs[0] != errHowdy || errs[1] != errHowdy {
+		t.Errorf("Expected Errors of both failures, got %v", err)
+	}
+	if v != 0 {
+		t.Errorf("Expected the zero value, got %v", v)
+	}
+}
+
+func TestFunc(t *testing.T) {
+	r := Func(func(ctx context.Context) (int, error) { return 42, nil })
+	if v := Run(context.TODO(), 10*time.Second, r); v != 42 {
+		t.Errorf("Expected 42, got %v", v)
+	}
+}
+
+func TestRunTypedCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	release := make(chan struct{})
+	defer close(release)
+	v, err := RunTyped(ctx, 10*time.Second, func(ctx context.Context) (int, error) {
+		<-release
+		return 42, nil
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if v != 0 {
+		t.Errorf("Expected zero value, got %d", v)
+	}
+}
+
+type counting struct {
+	calls int32
+	last  int32
+}
+
+func (c *counting) Req(ctx context.Context) (interface{}, error) {
+	i := atomic.AddInt32(&c.calls, 1)
+	if i == c.last {
+		return i, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRunNCount(t *testing.T) {
+	for _, n := range []int{0, 1, 3} {
+		c := &counting{last: int32(n + 1)}
+		wait := 1 * time.Millisecond
+		RunN(context.TODO(), wait, n, c)
+		// Give any stray request time to be counted.
+		time.Sleep(10 * wait)
+		if calls := atomic.LoadInt32(&c.calls); calls != int32(n+1) {
+			t.Errorf("n=%d: Expected %d calls, got %d", n, n+1, calls)
+		}
+	}
+}
+
+func TestRunNNegative(t *testing.T) {
+	for _, n := range []int{-1, -5} {
+		c := &counting{last: 1}
+		if v := RunN(context.TODO(), 0, n, c); v != int32(1) {
+			t.Errorf("n=%d: Expected 1, got %v", n, v)
+		}
+		if calls := atomic.LoadInt32(&c.calls); calls != 1 {
+			t.Errorf("n=%d: Expected only the original sent, got %d calls", n, calls)
+		}
+		var results int
+		for range RunAll(context.TODO(), 0, n, &str{"howdy"}) {
+			results++
+		}
+		if results != 1 {
+			t.Errorf("n=%d: Expected 1 result, got %d", n, results)
+		}
+	}
+}
+
+// BenchmarkRunN measures the overhead of a run whose original wins at once,
+// the common case, mostly in allocations.
+func BenchmarkRunN(b *testing.B) {
+	ctx := context.TODO()
+	r := RequestFunc(func(context.Context) (interface{}, error) { return "howdy", nil })
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		RunN(ctx, 1*time.Millisecond, 1, r)
+	}
+}
+
+// TestRunNConcurrentCalls checks, under -race, that concurrent runs share
+// nothing unsafely, such as the options of runs without any.
+func TestRunNConcurrentCalls(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				c := &counting{last: int32(j%3 + 1)}
+				if v := RunN(context.TODO(), 100*time.Microsecond, 2, c); v != c.last {
+					t.Errorf("Expected %d, got %v", c.last, v)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkRunNTimers(b *testing.B) {
+	ctx := context.TODO()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c := &counting{last: 11}
+		RunN(ctx, 1*time.Microsecond, 10, c)
+	}
+}
+
+func TestRunIndexed(t *testing.T) {
+	c := &counting{last: 3}
+	v, i := RunIndexed(context.TODO(), 5*time.Millisecond, 3, c)
+	if v != int32(3) || i != 2 {
+		t.Errorf("Expected 3 from attempt 2, got %v from attempt %d", v, i)
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	release := make(chan struct{})
+	defer close(release)
+	v, i = RunIndexed(ctx, 10*time.Second, 1, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	}))
+	if v != context.Canceled || i != -1 {
+		t.Errorf("Expected context.Canceled from attempt -1, got %v from attempt %d", v, i)
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	var mu sync.Mutex
+	var sends []int
+	completes := make(chan int, 2)
+	opts := Options{
+		OnSend: func(attempt int) {
+			mu.Lock()
+			sends = append(sends, attempt)
+			mu.Unlock()
+		},
+		OnComplete: func(attempt int, err error, d time.Duration) {
+			completes <- attempt
+		},
+	}
+	c := &counting{last: 2}
+	RunOptions(context.TODO(), 1*time.Millisecond, 1, c, opts)
+
+	mu.Lock()
+	if len(sends) != 2 || sends[0] != 0 || sends[1] != 1 {
+		t.Errorf("Expected sends [0 1], got %v", sends)
+	}
+	mu.Unlock()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-completes:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("Expected 2 completions, got %d", i)
+		}
+	}
+}
+
+type fastFailure struct {
+	calls int32
+}
+
+func (f *fastFailure) Req(ctx context.Context) (interface{}, error) {
+	if atomic.AddInt32(&f.calls, 1) == 1 {
+		return nil, errHowdy
+	}
+	return "howdy", nil
+}
+
+func TestRetry(t *testing.T) {
+	f := &fastFailure{}
+	retryable := func(err error) bool { return err == errHowdy }
+	v, err := RunNE(context.TODO(), 1*time.Hour, 1, Retry(f, 2, retryable))
+	if v != "howdy" || err != nil || f.calls != 2 {
+		t.Errorf("Expected howdy after 2 calls, got %v, %v after %d", v, err, f.calls)
+	}
+
+	f = &fastFailure{}
+	v, err = RunNE(context.TODO(), 1*time.Hour, 1, Retry(f, 1, retryable))
+	if err != errHowdy || f.calls != 1 {
+		t.Errorf("Expected errHowdy after 1 call, got %v, %v after %d", v, err, f.calls)
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	var calls int32
+	r := Retry(RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 1 {
+			return "hedge", nil
+		}
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(5 * time.Millisecond)
+		return nil, errHowdy
+	}), 100, nil)
+	v, done := RunWithDone(context.TODO(), 1*time.Millisecond, 1, r)
+	if v != "hedge" {
+		t.Fatalf("Expected hedge, got %v", v)
+	}
+	<-done
+	if calls > 2 {
+		t.Errorf("Expected the original's retries cut short, got %d calls", calls)
+	}
+}
+
+func TestRunFirstSuccess(t *testing.T) {
+	v := RunFirstSuccess(context.TODO(), 1*time.Millisecond, 1, &fastFailure{})
+	if v != "howdy" {
+		t.Errorf("Expected howdy, got %v", v)
+	}
+}
+
+func TestRunFirstSuccessSlowOriginal(t *testing.T) {
+	cancelled := make(chan time.Duration, 1)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		switch attempt, _ := AttemptFromContext(ctx); attempt {
+		case 0:
+			time.Sleep(10 * time.Millisecond)
+			return "original", nil
+		case 1:
+			return nil, errHowdy
+		}
+		start := time.Now()
+		<-ctx.Done()
+		cancelled <- time.Since(start)
+		return nil, ctx.Err()
+	})
+	// The first hedge fails fast, and the second is still in flight when the
+	// original succeeds.
+	v := RunFirstSuccess(context.TODO(), 1*time.Millisecond, 2, r)
+	if v != "original" {
+		t.Fatalf("Expected original, got %v", v)
+	}
+	if d := <-cancelled; d > 50*time.Millisecond {
+		t.Errorf("Expected the second hedge cancelled right away, took %v", d)
+	}
+}
+
+func TestRunFirstSuccessAllFail(t *testing.T) {
+	var calls int32
+	v := RunFirstSuccess(context.TODO(), 1*time.Millisecond, 2, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errHowdy
+	}))
+	errs, ok := v.(Errors)
+	if !ok {
+		t.Fatalf("Expected Errors, got %v", v)
+	}
+	if len(errs) != 3 {
+		t.Errorf("Expected 3 errors, got %d", len(errs))
+	}
+	if !errors.Is(errs, errHowdy) {
+		t.Errorf("Expected errors.Is errHowdy for %v", errs)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	err := Errors{errHowdy, context.Canceled}
+	want := "hedged: 2 requests failed; attempt 0: howdy; attempt 1: context canceled"
+	if err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Error("Expected errors.Is context.Canceled")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	ctx := context.TODO()
+	hung := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	_, err := RunOptions(ctx, 10*time.Millisecond, 3, hung, Options{Timeout: 20 * time.Millisecond})
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) || timeout.Timeout != 20*time.Millisecond {
+		t.Fatalf("Expected TimeoutError, got %v", err)
+	}
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v to match ErrTimeout and context.DeadlineExceeded", err)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("Expected caller's context not done, got %v", ctx.Err())
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			<-ctx.Done()
+			errs <- ctx.Err()
+			return nil, ctx.Err()
+		}
+		return "howdy", nil
+	})
+	// The hung original times out long before the hedge is due, which is
+	// then sent right away.
+	start := time.Now()
+	v, err := RunOptions(context.TODO(), 1*time.Hour, 1, r, Options{AttemptTimeout: 10 * time.Millisecond})
+	if v != "howdy" || err != nil {
+		t.Fatalf("Expected howdy, got %v, %v", v, err)
+	}
+	if d := time.Since(start); d > 1*time.Second {
+		t.Errorf("Expected the original abandoned at its timeout, took %v", d)
+	}
+	if err := <-errs; err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestTimeoutCallerDeadline(t *testing.T) {
+	// The caller's own deadline passes through unchanged.
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Millisecond)
+	defer cancel()
+	hung := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	_, err := RunOptions(ctx, 10*time.Millisecond, 3, hung, Options{Timeout: 1 * time.Second})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	var jitters int32
+	opts := Options{
+		Jitter: func(base time.Duration) time.Duration {
+			atomic.AddInt32(&jitters, 1)
+			return 0
+		},
+	}
+	c := &counting{last: 4}
+	start := time.Now()
+	_, err := RunOptions(context.TODO(), 1*time.Hour, 3, c, opts)
+	if err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+	if time.Since(start) > 1*time.Second {
+		t.Error("Expected hedges to fire before the wait")
+	}
+	if jitters != 3 {
+		t.Errorf("Expected 3 calls to Jitter, got %d", jitters)
+	}
+}
+
+func TestFullJitter(t *testing.T) {
+	base := 10 * time.Millisecond
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		d := FullJitter(base)
+		if d < 0 || d >= base {
+			t.Fatalf("Expected jitter in [0, %v), got %v", base, d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Error("Expected jitter to vary")
+	}
+
+	// Concurrent calls each take a generator of their own.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if d := FullJitter(base); d < 0 || d >= base {
+					t.Errorf("Expected jitter in [0, %v), got %v", base, d)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewFullJitterNil(t *testing.T) {
+	jitter := NewFullJitter(nil)
+	base := 10 * time.Millisecond
+	for i := 0; i < 10; i++ {
+		if d := jitter(base); d < 0 || d >= base {
+			t.Fatalf("Expected jitter in [0, %v), got %v", base, d)
+		}
+	}
+}
+
+type inFlight struct {
+	mu       sync.Mutex
+	now, max int
+	calls    int
+	last     int
+}
+
+func (f *inFlight) Req(ctx context.Context) (interface{}, error) {
+	f.mu.Lock()
+	f.calls++
+	call := f.calls
+	f.now++
+	if f.now > f.max {
+		f.max = f.now
+	}
+	f.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	f.mu.Lock()
+	f.now--
+	f.mu.Unlock()
+	if call < f.last {
+		return nil, errHowdy
+	}
+	return call, nil
+}
+
+func TestMaxInFlight(t *testing.T) {
+	f := &inFlight{last: 4}
+	opts := &Options{MaxInFlight: 2, firstSuccess: true}
+	res := run(context.TODO(), 1*time.Millisecond, 3, f, opts)
+	if res.Err != nil || res.Value != 4 {
+		t.Errorf("Expected 4, got %v, %v", res.Value, res.Err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.max != 2 {
+		t.Errorf("Expected at most 2 in flight, got %d", f.max)
+	}
+}
+
+func TestAttemptFromContext(t *testing.T) {
+	if _, ok := AttemptFromContext(context.TODO()); ok {
+		t.Error("Expected no attempt outside a request")
+	}
+	ctx := context.WithValue(context.TODO(), ctxKey, "howdy")
+	v, i := RunIndexed(ctx, 1*time.Millisecond, 1, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, ok := AttemptFromContext(ctx)
+		if !ok {
+			return nil, errHowdy
+		}
+		if attempt == 0 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return ctx.Value(ctxKey), nil
+	}))
+	if v != "howdy" || i != 1 {
+		t.Errorf("Expected howdy from attempt 1, got %v from attempt %d", v, i)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	v, err := RunNE(context.TODO(), 1*time.Millisecond, 1, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			panic("howdy")
+		}
+		return "hedge", nil
+	}))
+	if err != nil || v != "hedge" {
+		t.Errorf("Expected hedge, got %v, %v", v, err)
+	}
+}
+
+func TestPanicAll(t *testing.T) {
+	_, err := RunNE(context.TODO(), 1*time.Millisecond, 1, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		panic("howdy")
+	}))
+	var p PanicError
+	if !errors.As(err, &p) {
+		t.Fatalf("Expected PanicError, got %v", err)
+	}
+	if p.Value != "howdy" || len(p.Stack) == 0 {
+		t.Errorf("Expected howdy with a stack, got %v", p.Value)
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	c := &counting{last: 3}
+	v, stats := RunStats(context.TODO(), 5*time.Millisecond, 3, c)
+	if v != int32(3) {
+		t.Errorf("Expected 3, got %v", v)
+	}
+	if stats.Sent != 3 {
+		t.Errorf("Expected 3 sent, got %d", stats.Sent)
+	}
+	if stats.WinningAttempt != 2 {
+		t.Errorf("Expected attempt 2 to win, got %d", stats.WinningAttempt)
+	}
+	if stats.Elapsed < 10*time.Millisecond {
+		t.Errorf("Expected at least 10ms elapsed, got %v", stats.Elapsed)
+	}
+	if len(stats.Durations) != 3 {
+		t.Fatalf("Expected 3 durations, got %v", stats.Durations)
+	}
+	// The losers were still in flight when the winner returned.
+	if stats.Durations[0] != 0 || stats.Durations[1] != 0 || stats.Durations[2] <= 0 {
+		t.Errorf("Expected only the winner's duration, got %v", stats.Durations)
+	}
+}
+
+func TestRunOptionsStatsDelays(t *testing.T) {
+	clock := newFakeClock()
+	opts := Options{
+		Clock: clock,
+		Backoff: func(attempt int, base time.Duration) time.Duration {
+			return time.Duration(attempt) * base
+		},
+	}
+	type run struct {
+		v     interface{}
+		stats Stats
+	}
+	done := make(chan run)
+	go func() {
+		v, stats, _ := RunOptionsStats(context.TODO(), 10*time.Millisecond, 3, &counting{last: 4}, opts)
+		done <- run{v, stats}
+	}()
+	for _, d := range []time.Duration{10, 20, 30} {
+		clock.BlockUntil(1)
+		clock.Advance(d * time.Millisecond)
+	}
+	got := <-done
+	if got.v != int32(4) {
+		t.Errorf("Expected 4, got %v", got.v)
+	}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond}
+	if fmt.Sprint(got.stats.Delays) != fmt.Sprint(want) {
+		t.Errorf("Expected delays %v, got %v", want, got.stats.Delays)
+	}
+}
+
+func TestStatsAttemptErrors(t *testing.T) {
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		switch attempt, _ := AttemptFromContext(ctx); attempt {
+		case 0:
+			return nil, errHowdy
+		case 1:
+			return "howdy", nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	opts := Options{RetryOn: func(err error) bool { return err == errHowdy }}
+	v, stats, err := RunOptionsStats(context.TODO(), 1*time.Hour, 2, r, opts)
+	if v != "howdy" || err != nil {
+		t.Fatalf("Expected howdy, got %v, %v", v, err)
+	}
+	if want := []error{errHowdy, nil}; fmt.Sprint(stats.AttemptErrors) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, stats.AttemptErrors)
+	}
+}
+
+func TestRunConcurrent(t *testing.T) {
+	for _, wait := range []time.Duration{0, -1 * time.Second} {
+		// However fast the original, every request is sent.
+		v, stats := RunStats(context.TODO(), wait, 3, &str{"howdy"})
+		if v != "howdy" || stats.Sent != 4 {
+			t.Errorf("Expected howdy from 4 sent at wait %v, got %v from %d", wait, v, stats.Sent)
+		}
+	}
+	if v := RunConcurrent(context.TODO(), 3, &str{"howdy"}); v != "howdy" {
+		t.Errorf("Expected howdy, got %v", v)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []int
+	opts := Options{
+		Backoff: func(attempt int, base time.Duration) time.Duration {
+			mu.Lock()
+			attempts = append(attempts, attempt)
+			mu.Unlock()
+			return ExponentialBackoff(attempt, base)
+		},
+	}
+	start := time.Now()
+	RunOptions(context.TODO(), 2*time.Millisecond, 3, &counting{last: 4}, opts)
+	// 2ms, then 4ms, then 8ms.
+	if elapsed := time.Since(start); elapsed < 14*time.Millisecond {
+		t.Errorf("Expected at least 14ms elapsed, got %v", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[1] != 2 || attempts[2] != 3 {
+		t.Errorf("Expected backoff for hedges [1 2 3], got %v", attempts)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	base := 10 * time.Millisecond
+	for attempt, want := range []time.Duration{base, base, 2 * base, 4 * base} {
+		if d := ExponentialBackoff(attempt, base); d != want {
+			t.Errorf("Attempt %d: Expected %v, got %v", attempt, want, d)
+		}
+	}
+}
+
+func TestRunResult(t *testing.T) {
+	res := RunResult(context.TODO(), 10*time.Second, 1, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		return errHowdy, nil
+	}))
+	if res.Value != errHowdy || res.Err != nil || res.Attempt != 0 {
+		t.Errorf("Expected errHowdy value from attempt 0, got %+v", res)
+	}
+}
+
+func TestRunStreaming(t *testing.T) {
+	ctxs := make(chan context.Context, 2)
+	res, release := RunStreaming(context.TODO(), 1*time.Millisecond, 1, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		ctxs <- ctx
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return "howdy", nil
+	}))
+	if res.Value != "howdy" || res.Attempt != 1 {
+		t.Fatalf("Expected howdy from attempt 1, got %+v", res)
+	}
+	var winner context.Context
+	for i := 0; i < 2; i++ {
+		ctx := <-ctxs
+		if attempt, _ := AttemptFromContext(ctx); attempt == 1 {
+			winner = ctx
+		} else if ctx.Err() == nil {
+			t.Error("Expected the loser's context cancelled")
+		}
+	}
+	if winner.Err() != nil {
+		t.Errorf("Expected the winner's context alive, got %v", winner.Err())
+	}
+	release()
+	if winner.Err() != context.Canceled {
+		t.Errorf("Expected the winner's context cancelled on release, got %v", winner.Err())
+	}
+}
+
+// peak tracks the most calls in flight at once, between enter and exit.
+type peak struct {
+	inFlight, most int32
+}
+
+func (p *peak) enter() {
+	n := atomic.AddInt32(&p.inFlight, 1)
+	for {
+		m := atomic.LoadInt32(&p.most)
+		if n <= m || atomic.CompareAndSwapInt32(&p.most, m, n) {
+			return
+		}
+	}
+}
+
+func (p *peak) exit() {
+	atomic.AddInt32(&p.inFlight, -1)
+}
+
+// max returns the most calls in flight at once so far.
+func (p *peak) max() int32 {
+	return atomic.LoadInt32(&p.most)
+}
+
+type denyAll struct {
+	calls int32
+}
+
+func (d *denyAll) Allow() bool {
+	atomic.AddInt32(&d.calls, 1)
+	return false
+}
+
+func TestLimiter(t *testing.T) {
+	l := &denyAll{}
+	var calls int32
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return "howdy", nil
+	})
+	v, err := RunOptions(context.TODO(), 1*time.Millisecond, 3, r, Options{Limiter: l})
+	if err != nil || v != "howdy" {
+		t.Errorf("Expected howdy, got %v, %v", v, err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected only the original sent, got %d calls", calls)
+	}
+	if l.calls != 3 {
+		t.Errorf("Expected the limiter consulted for 3 hedges, got %d", l.calls)
+	}
+}
+
+func TestShouldHedge(t *testing.T) {
+	var asked, calls int32
+	opts := Options{
+		ShouldHedge: func() bool {
+			atomic.AddInt32(&asked, 1)
+			return false
+		},
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return "howdy", nil
+	})
+	v, err := RunOptions(context.TODO(), 1*time.Millisecond, 2, r, opts)
+	if err != nil || v != "howdy" {
+		t.Errorf("Expected howdy, got %v, %v", v, err)
+	}
+	if calls != 1 || asked != 2 {
+		t.Errorf("Expected 1 call and 2 hedges declined, got %d and %d", calls, asked)
+	}
+}
+
+func TestRunAll(t *testing.T) {
+	seen := make(map[int]bool)
+	for res := range RunAll(context.TODO(), 1*time.Millisecond, 2, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		return attempt, nil
+	})) {
+		if res.Value != res.Attempt {
+			t.Errorf("Expected value %d, got %v", res.Attempt, res.Value)
+		}
+		seen[res.Attempt] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected 3 results, got %v", seen)
+	}
+}
+
+func TestRunAllOrdered(t *testing.T) {
+	delays := []time.Duration{20 * time.Millisecond, 0, 5 * time.Millisecond}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		time.Sleep(delays[attempt])
+		return attempt, nil
+	})
+	var got []int
+	for res := range RunAllOrdered(context.TODO(), 1*time.Millisecond, 2, r) {
+		got = append(got, res.Attempt)
+	}
+	if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("Expected 0, 1, 2, got %v", got)
+	}
+}
+
+func TestRunAllOrderedCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		if attempt == 0 {
+			// Ignores cancellation, so never returns in time.
+			time.Sleep(100 * time.Millisecond)
+		}
+		return attempt, nil
+	})
+	var got []int
+	for res := range RunAllOrdered(ctx, 1*time.Millisecond, 2, r) {
+		got = append(got, res.Attempt)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("Expected the held 1, 2, got %v", got)
+	}
+}
+
+func TestDrainAndCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.TODO())
+	ch := RunAll(ctx, 0, 5, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			return "howdy", nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	if res := <-ch; res.Value != "howdy" {
+		t.Fatalf("Expected howdy, got %+v", res)
+	}
+	DrainAndCancel(cancel, ch)
+	if _, ok := <-ch; ok {
+		t.Error("Expected channel closed")
+	}
+	if !eventually(func() bool { return runtime.NumGoroutine() <= before }) {
+		t.Errorf("Expected %d goroutines, got %d", before, runtime.NumGoroutine())
+	}
+}
+
+func TestAbandonedConsumers(t *testing.T) {
+	settle(t)
+	before := runtime.NumGoroutine()
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		time.Sleep(time.Duration(attempt) * time.Millisecond)
+		return attempt, nil
+	})
+	// Consumers that stop reading, or never start, without cancelling
+	// anything, can't leave the runs blocked on sending to them.
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			<-RunAll(context.TODO(), 0, 5, r)
+		}()
+		go func() {
+			defer wg.Done()
+			RunAllOrdered(context.TODO(), 0, 5, r)
+		}()
+		go func() {
+			defer wg.Done()
+			RunWithLosers(context.TODO(), 0, 5, r)
+		}()
+	}
+	wg.Wait()
+	if !eventually(func() bool { return runtime.NumGoroutine() <= before }) {
+		t.Errorf("Expected %d goroutines, got %d", before, runtime.NumGoroutine())
+	}
+}
+
+func TestRunAllInto(t *testing.T) {
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		if attempt == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return attempt, nil
+	})
+	buf := make([]Result, 1, 4)
+	got := RunAllInto(context.TODO(), 1*time.Millisecond, 2, r, buf)
+	if len(got) != 4 || &got[0] != &buf[0] {
+		t.Fatalf("Expected 3 results appended to buf, got %+v", got)
+	}
+	// The original is slowest, so it completes last.
+	if got[3].Attempt != 0 || got[3].Value != 0 {
+		t.Errorf("Expected the original last, got %+v", got[1:])
+	}
+}
+
+func BenchmarkRunAllInto(b *testing.B) {
+	ctx := context.TODO()
+	s := &str{"howdy"}
+	var buf []Result
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = RunAllInto(ctx, 0, 2, s, buf[:0])
+	}
+}
+
+func TestCancelCause(t *testing.T) {
+	causes := make(chan error, 1)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 1 {
+			return "howdy", nil
+		}
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		return nil, ctx.Err()
+	})
+	if v := Run(context.TODO(), 1*time.Millisecond, r); v != "howdy" {
+		t.Fatalf("Expected howdy, got %v", v)
+	}
+	if cause := <-causes; cause != ErrWinnerChosen {
+		t.Errorf("Expected ErrWinnerChosen, got %v", cause)
+	}
+
+	// Cancelled by the caller, the loser learns the caller's cause.
+	ctx, cancel := context.WithCancelCause(context.TODO())
+	Run(ctx, 1*time.Second, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		cancel(errHowdy)
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		return nil, ctx.Err()
+	}))
+	if cause := <-causes; cause != errHowdy {
+		t.Errorf("Expected %v, got %v", errHowdy, cause)
+	}
+}
+
+func TestInitialDelay(t *testing.T) {
+	clock := newFakeClock()
+	var sent int32
+	opts := Options{
+		InitialDelay: 10 * time.Millisecond,
+		Clock:        clock,
+		OnSend:       func(int) { atomic.AddInt32(&sent, 1) },
+	}
+	done := make(chan interface{})
+	go func() {
+		v, _ := RunOptions(context.TODO(), 1*time.Second, 1, &str{"howdy"}, opts)
+		done <- v
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(9 * time.Millisecond)
+	if n := atomic.LoadInt32(&sent); n != 0 {
+		t.Fatalf("Expected nothing sent during the delay, got %d", n)
+	}
+	clock.Advance(1 * time.Millisecond)
+	if v := <-done; v != "howdy" {
+		t.Errorf("Expected howdy, got %v", v)
+	}
+}
+
+func TestReason(t *testing.T) {
+	reasons := make(chan error, 1)
+	hung := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 1 {
+			return "howdy", nil
+		}
+		<-ctx.Done()
+		reasons <- Reason(ctx)
+		return nil, ctx.Err()
+	})
+	Run(context.TODO(), 1*time.Millisecond, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 && Reason(ctx) != nil {
+			t.Error("Expected no reason before being cancelled")
+		}
+		return hung(ctx)
+	}))
+	if reason := <-reasons; reason != ErrWinnerChosen {
+		t.Errorf("Expected ErrWinnerChosen, got %v", reason)
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	Run(ctx, 1*time.Hour, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		cancel()
+		return hung(ctx)
+	}))
+	if reason := <-reasons; reason != ErrCallerCancelled {
+		t.Errorf("Expected ErrCallerCancelled, got %v", reason)
+	}
+
+	RunOptions(context.TODO(), 1*time.Hour, 1, hung, Options{AttemptTimeout: 1 * time.Millisecond})
+	if reason := <-reasons; reason != ErrHedgeTimeout {
+		t.Errorf("Expected ErrHedgeTimeout, got %v", reason)
+	}
+
+	RunOptions(context.TODO(), 1*time.Hour, 1, hung, Options{Timeout: 1 * time.Millisecond})
+	if reason := <-reasons; !errors.Is(reason, ErrTimeout) {
+		t.Errorf("Expected a *TimeoutError, got %v", reason)
+	}
+
+	ctx, cancel = context.WithCancel(context.TODO())
+	cancel()
+	if reason := Reason(ctx); reason != nil {
+		t.Errorf("Expected no reason outside the package, got %v", reason)
+	}
+}
+
+func TestHedge(t *testing.T) {
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 1 {
+			return "hedge", nil
+		}
+		// The original knows it will be slow.
+		Hedge(ctx)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	start := time.Now()
+	if v := Run(context.TODO(), 1*time.Hour, r); v != "hedge" {
+		t.Errorf("Expected hedge, got %v", v)
+	}
+	if d := time.Since(start); d > 1*time.Second {
+		t.Errorf("Expected the hedge sent right away, took %v", d)
+	}
+	// Outside of a request, it does nothing.
+	Hedge(context.TODO())
+}
+
+func TestInitialDelayCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Millisecond)
+	defer cancel()
+	c := &counting{}
+	_, err := RunOptions(ctx, 0, 1, c, Options{InitialDelay: 1 * time.Second})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+	if calls := atomic.LoadInt32(&c.calls); calls != 0 {
+		t.Errorf("Expected no calls, got %d", calls)
+	}
+}
+
+func TestPreferTie(t *testing.T) {
+	release := make(chan struct{})
+	opts := Options{
+		OnSend: func(attempt int) {
+			if attempt == 2 {
+				// Let the first two complete together, before the run
+				// receives either.
+				close(release)
+				time.Sleep(20 * time.Millisecond)
+			}
+		},
+		Prefer: func(a, b Result) Result {
+			if b.Attempt < a.Attempt {
+				return b
+			}
+			return a
+		},
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		if attempt == 2 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		<-release
+		return attempt, nil
+	})
+	if v, _ := RunOptions(context.TODO(), 0, 2, r, opts); v != 0 {
+		t.Errorf("Expected the original preferred, got %v", v)
+	}
+}
+
+func TestOnSavings(t *testing.T) {
+	type savings struct {
+		saved  time.Duration
+		hedged bool
+	}
+	clock := newFakeClock()
+	got := make(chan savings, 1)
+	opts := Options{
+		Clock:     clock,
+		OnSavings: func(saved time.Duration, hedged bool) { got <- savings{saved, hedged} },
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		// Both ignore cancellation.
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			<-clock.After(50 * time.Millisecond)
+			return "original", nil
+		}
+		<-clock.After(10 * time.Millisecond)
+		return "hedge", nil
+	})
+	done := make(chan interface{})
+	go func() {
+		v, _ := RunOptions(context.TODO(), 10*time.Millisecond, 1, r, opts)
+		done <- v
+	}()
+	clock.BlockUntil(2)
+	clock.Advance(10 * time.Millisecond)
+	clock.BlockUntil(2)
+	clock.Advance(10 * time.Millisecond)
+	if v := <-done; v != "hedge" {
+		t.Fatalf("Expected hedge, got %v", v)
+	}
+	clock.Advance(30 * time.Millisecond)
+	if s := <-got; s != (savings{30 * time.Millisecond, true}) {
+		t.Errorf("Expected 30ms saved by hedging, got %+v", s)
+	}
+
+	// Without a hedge, nothing is saved.
+	RunOptions(context.TODO(), 1*time.Second, 1, &str{"howdy"}, opts)
+	if s := <-got; s != (savings{0, false}) {
+		t.Errorf("Expected nothing saved, got %+v", s)
+	}
+}
+
+// closer records being closed.
+type closer struct {
+	attempt int
+	closed  chan int
+}
+
+func (c *closer) Close() error {
+	c.closed <- c.attempt
+	return nil
+}
+
+func TestAutoCloseLosers(t *testing.T) {
+	closed := make(chan int, 3)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		if attempt != 2 {
+			<-ctx.Done()
+		}
+		return &closer{attempt, closed}, nil
+	})
+	v, err := RunOptions(context.TODO(), 1*time.Millisecond, 2, r, Options{AutoCloseLosers: true})
+	if err != nil || v.(*closer).attempt != 2 {
+		t.Fatalf("Expected attempt 2 to win, got %v, %v", v, err)
+	}
+	got := map[int]bool{<-closed: true, <-closed: true}
+	if !got[0] || !got[1] {
+		t.Errorf("Expected losers 0 and 1 closed, got %v", got)
+	}
+	settle(t)
+	select {
+	case attempt := <-closed:
+		t.Errorf("Expected the winner open, got %d closed", attempt)
+	default:
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	cleaned := make(chan int, 6)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		switch attempt {
+		case 0:
+			// Fails, but only once the hedges won.
+			time.Sleep(5 * time.Millisecond)
+			return nil, errHowdy
+		case 1:
+			return "howdy", nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	opts := Options{
+		Cleanup: func(attempt int, v interface{}, err error) { cleaned <- attempt },
+	}
+	v, err := RunOptions(context.TODO(), 0, 2, r, opts)
+	if err != nil || v != "howdy" {
+		t.Fatalf("Expected howdy, got %v, %v", v, err)
+	}
+	got := map[int]int{}
+	for i := 0; i < 3; i++ {
+		got[<-cleaned]++
+	}
+	settle(t)
+	if len(cleaned) != 0 || got[0] != 1 || got[1] != 1 || got[2] != 1 {
+		t.Errorf("Expected every attempt cleaned up once, got %v and %d more", got, len(cleaned))
+	}
+
+	// A hedge failing during Grace is cleaned up once too.
+	r = RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			return "howdy", nil
+		}
+		time.Sleep(5 * time.Millisecond)
+		return nil, errHowdy
+	})
+	opts.RetryOn = func(error) bool { return true }
+	opts.Grace = 200 * time.Millisecond
+	opts.Prefer = func(a, b Result) Result { return a }
+	if v, err := RunOptions(context.TODO(), 0, 1, r, opts); err != nil || v != "howdy" {
+		t.Fatalf("Expected howdy, got %v, %v", v, err)
+	}
+	got = map[int]int{}
+	for i := 0; i < 2; i++ {
+		got[<-cleaned]++
+	}
+	settle(t)
+	if len(cleaned) != 0 || got[0] != 1 || got[1] != 1 {
+		t.Errorf("Expected every attempt cleaned up once, got %v and %d more", got, len(cleaned))
+	}
+}
+
+func TestCleanupAbandoned(t *testing.T) {
+	cleaned := make(chan int, 2)
+	release := make(chan struct{})
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			// Ignores cancellation for longer than ReapTimeout.
+			<-release
+		}
+		return "howdy", nil
+	})
+	opts := Options{
+		Cleanup:     func(attempt int, v interface{}, err error) { cleaned <- attempt },
+		ReapTimeout: 1 * time.Millisecond,
+	}
+	if _, err := RunOptions(context.TODO(), 1*time.Millisecond, 1, r, opts); err != nil {
+		t.Fatal(err)
+	}
+	if attempt := <-cleaned; attempt != 1 {
+		t.Fatalf("Expected the winner cleaned up first, got %d", attempt)
+	}
+	settle(t)
+	close(release)
+	if attempt := <-cleaned; attempt != 0 {
+		t.Errorf("Expected the abandoned original cleaned up, got %d", attempt)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if _, err := RunOptions(context.TODO(), 1*time.Millisecond, 1, &counting{last: 2}, Options{Logger: logger}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`msg="hedged: request sent" attempt=0 delay=`,
+		`msg="hedged: request sent" attempt=1 delay=`,
+		`msg="hedged: request won" attempt=1 duration=`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %s logged, got\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestKeepLosers(t *testing.T) {
+	original := make(chan context.Context, 1)
+	completed := make(chan bool, 1)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 1 {
+			return "hedge", nil
+		}
+		original <- ctx
+		select {
+		case <-time.After(20 * time.Millisecond):
+			completed <- true
+			return "original", nil
+		case <-ctx.Done():
+			completed <- false
+			return nil, ctx.Err()
+		}
+	})
+	v, err := RunOptions(context.TODO(), 1*time.Millisecond, 1, r, Options{KeepLosers: true})
+	if err != nil || v != "hedge" {
+		t.Fatalf("Expected hedge, got %v, %v", v, err)
+	}
+	if !<-completed {
+		t.Fatal("Expected the original run to completion")
+	}
+	settle(t)
+	if ctx := <-original; context.Cause(ctx) != ErrWinnerChosen {
+		t.Errorf("Expected the original's context cancelled once reaped, got %v", context.Cause(ctx))
+	}
+}
+
+func TestTransform(t *testing.T) {
+	type hostKey struct{}
+	hosts := []string{"primary", "replica"}
+	got := make(chan string, 2)
+	opts := Options{
+		Transform: func(ctx context.Context, attempt int) context.Context {
+			return context.WithValue(ctx, hostKey{}, hosts[attempt])
+		},
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		got <- ctx.Value(hostKey{}).(string)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	RunOptions(ctx, 1*time.Millisecond, 1, r, opts)
+	if a, b := <-got, <-got; a != "primary" || b != "replica" {
+		t.Errorf("Expected primary, replica, got %s, %s", a, b)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	type tagged struct {
+		v       interface{}
+		attempt int
+	}
+	opts := Options{
+		Annotate: func(v interface{}, attempt int) interface{} { return tagged{v, attempt} },
+	}
+	v, err := RunOptions(context.TODO(), 1*time.Millisecond, 2, &counting{last: 2}, opts)
+	if err != nil || v != (tagged{int32(2), 1}) {
+		t.Errorf("Expected 2 tagged with attempt 1, got %v, %v", v, err)
+	}
+	_, err = RunOptions(context.TODO(), 0, 0, RequestFunc(func(context.Context) (interface{}, error) {
+		return nil, errHowdy
+	}), opts)
+	if err != errHowdy {
+		t.Errorf("Expected errHowdy untagged, got %v", err)
+	}
+}
+
+func TestRunWithLosers(t *testing.T) {
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		if attempt < 2 {
+			// Ignores cancellation, as a read to replicas might.
+			time.Sleep(20 * time.Millisecond)
+		}
+		return attempt, nil
+	})
+	winner, losers := RunWithLosers(context.TODO(), 1*time.Millisecond, 2, r)
+	if winner.Value != 2 {
+		t.Fatalf("Expected attempt 2 to win, got %+v", winner)
+	}
+	seen := make(map[interface{}]bool)
+	for res := range losers {
+		if res.Err != nil || res.Value != res.Attempt {
+			t.Errorf("Expected the loser's value, got %+v", res)
+		}
+		seen[res.Value] = true
+	}
+	if len(seen) != 2 || !seen[0] || !seen[1] {
+		t.Errorf("Expected losers 0 and 1, got %v", seen)
+	}
+}
+
+func TestMinUseful(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	var hedges int32
+	opts := Options{
+		MinUseful: 50 * time.Millisecond,
+		OnSend: func(attempt int) {
+			if attempt > 0 {
+				atomic.AddInt32(&hedges, 1)
+			}
+		},
+	}
+	// Hedges are due at 30ms and 60ms, but only the first leaves enough time.
+	RunOptions(ctx, 30*time.Millisecond, 2, &counting{}, opts)
+	if hedges != 1 {
+		t.Errorf("Expected 1 hedge, got %d", hedges)
+	}
+}
+
+func TestGauge(t *testing.T) {
+	var reads, hedges int32
+	opts := Options{
+		Gauge: func() float64 {
+			atomic.AddInt32(&reads, 1)
+			return 1
+		},
+		OnSend: func(attempt int) {
+			if attempt > 0 {
+				atomic.AddInt32(&hedges, 1)
+			}
+		},
+	}
+	slow := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		time.Sleep(10 * time.Millisecond)
+		return "original", nil
+	})
+	v, err := RunOptions(context.TODO(), 1*time.Millisecond, 3, slow, opts)
+	if v != "original" || err != nil {
+		t.Errorf("Expected original, got %v, %v", v, err)
+	}
+	if hedges != 0 || reads != 1 {
+		t.Errorf("Expected every hedge suppressed after 1 read, got %d hedges after %d", hedges, reads)
+	}
+
+	// Below MaxLoad, hedges go out.
+	opts.Gauge = func() float64 { return 0.5 }
+	RunOptions(context.TODO(), 1*time.Millisecond, 1, &counting{last: 2}, opts)
+	if hedges != 1 {
+		t.Errorf("Expected 1 hedge, got %d", hedges)
+	}
+}
+
+func TestDeadlineMargin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 300*time.Millisecond)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	deadlines := make(chan time.Time, 4)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		d, _ := ctx.Deadline()
+		deadlines <- d
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	// Hedges are due at 50ms, 100ms and 150ms, each with a deadline sooner by
+	// that time plus the margin: the third would be left no time. The
+	// hedges failing early lose, leaving the original to fail.
+	_, err := RunOptions(ctx, 50*time.Millisecond, 3, r, Options{DeadlineMargin: 50 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) || ctx.Err() == nil {
+		t.Errorf("Expected the original to fail with ctx, got %v", err)
+	}
+	if len(deadlines) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(deadlines))
+	}
+	if d := <-deadlines; !d.Equal(deadline) {
+		t.Errorf("Expected the original to keep the deadline, got %v less", deadline.Sub(d))
+	}
+	first := <-deadlines
+	if sooner := deadline.Sub(first); sooner < 100*time.Millisecond {
+		t.Errorf("Expected the first hedge's deadline at least 100ms sooner, got %v sooner", sooner)
+	}
+	if sooner := first.Sub(<-deadlines); sooner < 50*time.Millisecond {
+		t.Errorf("Expected the second hedge's deadline at least 50ms sooner still, got %v sooner", sooner)
+	}
+}
+
+func TestDeadlineMarginClock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 1*time.Second)
+	defer cancel()
+	clock := newFakeClock()
+	go func() {
+		// An hour passes by the Clock, though next to none really does.
+		clock.BlockUntil(1)
+		clock.Advance(1 * time.Hour)
+	}()
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return "hedge", nil
+	})
+	opts := Options{Clock: clock, DeadlineMargin: 10 * time.Millisecond}
+	if v, err := RunOptions(ctx, 1*time.Hour, 1, r, opts); err != nil || v != "hedge" {
+		t.Errorf("Expected the hedge sent and won, got %v, %v", v, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	discarded := make(chan interface{}, 3)
+	opts := Options{
+		Validate: func(v interface{}) bool { return v != "stale" },
+		Discard:  func(v interface{}) { discarded <- v },
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			return "stale", nil
+		}
+		return "fresh", nil
+	})
+	// The rejection sends the hedge right away, not after the wait.
+	v, err := RunOptions(context.TODO(), 1*time.Hour, 1, r, opts)
+	if v != "fresh" || err != nil {
+		t.Errorf("Expected fresh, got %v, %v", v, err)
+	}
+	if v := <-discarded; v != "stale" {
+		t.Errorf("Expected stale discarded, got %v", v)
+	}
+}
+
+func TestValidateNone(t *testing.T) {
+	discarded := make(chan interface{}, 3)
+	opts := Options{
+		Validate: func(interface{}) bool { return false },
+		Discard:  func(v interface{}) { discarded <- v },
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		return attempt, nil
+	})
+	v, err := RunOptions(context.TODO(), 1*time.Hour, 2, r, opts)
+	if v != 2 || err != ErrNoValidResult {
+		t.Errorf("Expected 2 with ErrNoValidResult, got %v, %v", v, err)
+	}
+	got := map[interface{}]bool{<-discarded: true, <-discarded: true}
+	if !got[0] || !got[1] {
+		t.Errorf("Expected 0 and 1 discarded, got %v", got)
+	}
+}
+
+func TestAcceptable(t *testing.T) {
+	opts := Options{
+		Acceptable: func(v interface{}, err error) bool { return err == nil && v.(int) < 500 },
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			return 503, nil
+		}
+		return 200, nil
+	})
+	v, err := RunOptions(context.TODO(), 1*time.Millisecond, 1, r, opts)
+	if v != 200 || err != nil {
+		t.Errorf("Expected 200, got %v, %v", v, err)
+	}
+}
+
+func TestAcceptableNone(t *testing.T) {
+	opts := Options{
+		Acceptable: func(v interface{}, err error) bool { return err == nil },
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		return attempt, errHowdy
+	})
+	v, err := RunOptions(context.TODO(), 1*time.Millisecond, 2, r, opts)
+	if v != 2 || !errors.Is(err, ErrNoAcceptableResult) || !errors.Is(err, errHowdy) {
+		t.Errorf("Expected 2 with ErrNoAcceptableResult and errHowdy, got %v, %v", v, err)
+	}
+}
+
+func TestRank(t *testing.T) {
+	statuses := []int{503, 429, 500}
+	discarded := make(chan interface{}, 3)
+	opts := Options{
+		Acceptable: func(v interface{}, err error) bool { return v.(int) < 400 },
+		// The lower the status, the better.
+		Rank:    func(a, b interface{}) int { return b.(int) - a.(int) },
+		Discard: func(v interface{}) { discarded <- v },
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		return statuses[attempt], nil
+	})
+	v, err := RunOptions(context.TODO(), 1*time.Millisecond, 2, r, opts)
+	if v != 429 || err != ErrNoAcceptableResult {
+		t.Errorf("Expected 429 with ErrNoAcceptableResult, got %v, %v", v, err)
+	}
+	got := map[interface{}]bool{<-discarded: true, <-discarded: true}
+	if !got[503] || !got[500] {
+		t.Errorf("Expected 503 and 500 discarded, got %v", got)
+	}
+}
+
+func TestMaxInFlightLargeN(t *testing.T) {
+	const n, max = 100, 4
+	var p peak
+	var sent int32
+	opts := Options{
+		MaxInFlight: max,
+		RetryOn:     func(error) bool { return true },
+		OnSend:      func(int) { atomic.AddInt32(&sent, 1) },
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		p.enter()
+		defer p.exit()
+		time.Sleep(100 * time.Microsecond)
+		if attempt, _ := AttemptFromContext(ctx); attempt == n {
+			return "howdy", nil
+		}
+		return nil, errHowdy
+	})
+	v, err := RunOptions(context.TODO(), 1*time.Microsecond, n, r, opts)
+	if v != "howdy" || err != nil {
+		t.Fatalf("Expected howdy, got %v, %v", v, err)
+	}
+	if most := p.max(); most > max {
+		t.Errorf("Expected at most %d running at once, got %d", max, most)
+	}
+	if sent != n+1 {
+		t.Errorf("Expected %d sent, got %d", n+1, sent)
+	}
+}
+
+func TestRunWithDone(t *testing.T) {
+	release := make(chan struct{})
+	v, done := RunWithDone(context.TODO(), 1*time.Millisecond, 1, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			<-release
+			return "loser", nil
+		}
+		return "howdy", nil
+	}))
+	if v != "howdy" {
+		t.Fatalf("Expected howdy, got %v", v)
+	}
+	select {
+	case <-done:
+		t.Fatal("Expected done only once the loser returns")
+	case <-time.After(5 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Error("Expected done once the loser returned")
+	}
+}
+
+func TestRunWithFallback(t *testing.T) {
+	var calls int32
+	primary := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errHowdy
+	})
+	fallback := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return "cached", nil
+	})
+	v, err := RunWithFallback(context.TODO(), 1*time.Millisecond, 1, primary, fallback)
+	if v != "cached" || err != nil {
+		t.Errorf("Expected cached, got %v, %v", v, err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 primary calls, got %d", calls)
+	}
+
+	// A primary success doesn't fall back.
+	v, err = RunWithFallback(context.TODO(), 1*time.Millisecond, 1, &str{"howdy"}, fallback)
+	if v != "howdy" || err != nil {
+		t.Errorf("Expected howdy, got %v, %v", v, err)
+	}
+}
+
+func TestRunAllCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	ch := RunAll(ctx, 1*time.Millisecond, 2, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			return "howdy", nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	if res := <-ch; res.Value != "howdy" {
+		t.Errorf("Expected howdy, got %+v", res)
+	}
+	cancel()
+	timeout := time.After(1 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Expected channel closed on cancel")
+		}
+	}
+}
+
+func TestSuppressHedge(t *testing.T) {
+	var calls int32
+	v, err := RunNE(context.TODO(), 5*time.Millisecond, 3, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		switch attempt, _ := AttemptFromContext(ctx); attempt {
+		case 0:
+			time.Sleep(30 * time.Millisecond)
+			return "original", nil
+		case 1:
+			atomic.AddInt32(&calls, 1)
+			return nil, ErrSuppressHedge
+		default:
+			atomic.AddInt32(&calls, 1)
+			return "hedge", nil
+		}
+	}))
+	if err != nil || v != "original" {
+		t.Errorf("Expected original, got %v, %v", v, err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected no hedges after suppression, got %d", calls)
+	}
+}
+
+func TestGrace(t *testing.T) {
+	opts := Options{
+		Grace: 50 * time.Millisecond,
+		// Prefer fresh values over stale ones.
+		Prefer: func(a, b Result) Result {
+			if a.Value == "stale" {
+				return b
+			}
+			return a
+		},
+	}
+	started := make(chan struct{})
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			<-started
+			return "stale", nil
+		}
+		close(started)
+		time.Sleep(5 * time.Millisecond)
+		return "fresh", nil
+	})
+	// The stale original lands first, the fresh hedge within the grace
+	// period.
+	v, err := RunOptions(context.TODO(), 0, 1, r, opts)
+	if err != nil || v != "fresh" {
+		t.Errorf("Expected fresh, got %v, %v", v, err)
+	}
+}
+
+func TestGraceExpires(t *testing.T) {
+	opts := Options{
+		Grace:  5 * time.Millisecond,
+		Prefer: func(a, b Result) Result { return b },
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			return "first", nil
+		}
+		<-ctx.Done()
+		return "late", nil
+	})
+	v, err := RunOptions(context.TODO(), 0, 1, r, opts)
+	if err != nil || v != "first" {
+		t.Errorf("Expected first, got %v, %v", v, err)
+	}
+}
+
+func TestPreferAttempt(t *testing.T) {
+	opts := Options{Grace: 50 * time.Millisecond, Prefer: PreferAttempt(0)}
+	started := make(chan struct{})
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 1 {
+			close(started)
+			return "secondary", nil
+		}
+		<-started
+		time.Sleep(5 * time.Millisecond)
+		return "primary", nil
+	})
+	// The secondary lands first, the primary within the grace period.
+	v, err := RunOptions(context.TODO(), 0, 1, r, opts)
+	if err != nil || v != "primary" {
+		t.Errorf("Expected primary, got %v, %v", v, err)
+	}
+
+	// A failing primary isn't preferred.
+	prefer := PreferAttempt(0)
+	secondary := Result{Value: "secondary", Attempt: 1}
+	if got := prefer(secondary, Result{Err: errHowdy}); got != secondary {
+		t.Errorf("Expected secondary, got %+v", got)
+	}
+}
+
+func TestPreferAttemptFirst(t *testing.T) {
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			return "primary", nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	h := New(WithWait(0), WithGrace(1*time.Hour, nil), WithPreferAttempt(0, 300*time.Millisecond))
+	opts := Options{Grace: 300 * time.Millisecond, PreferAttempt: 0}
+	for name, run := range map[string]func() (interface{}, error){
+		"Hedger":     func() (interface{}, error) { return h.Run(context.TODO(), r) },
+		"RunOptions": func() (interface{}, error) { return RunOptions(context.TODO(), 0, 1, r, opts) },
+	} {
+		// The primary lands first: there is nothing better to wait for.
+		start := time.Now()
+		v, err := run()
+		if err != nil || v != "primary" {
+			t.Errorf("%s: Expected primary, got %v, %v", name, v, err)
+		}
+		if d := time.Since(start); d > 150*time.Millisecond {
+			t.Errorf("%s: Expected no wait once the primary succeeded, took %v", name, d)
+		}
+	}
+
+	// No preference keeps the first result, waiting out Grace.
+	opts.PreferAttempt = -1
+	start := time.Now()
+	if v, err := RunOptions(context.TODO(), 0, 1, r, opts); err != nil || v != "primary" {
+		t.Errorf("Expected primary, got %v, %v", v, err)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("Expected Grace waited out, took %v", d)
+	}
+}
+
+type span struct {
+	attempt int
+	spans   chan<- span
+	winner  bool
+}
+
+func (s span) End(err error, winner bool) {
+	s.winner = winner
+	s.spans <- s
+}
+
+type tracer chan span
+
+func (t tracer) Start(ctx context.Context, attempt int) (context.Context, Span) {
+	return ctx, span{attempt: attempt, spans: t}
+}
+
+func TestTracer(t *testing.T) {
+	spans := make(tracer, 2)
+	opts := Options{Tracer: spans}
+	RunOptions(context.TODO(), 1*time.Millisecond, 1, &hungOdds{make(chan struct{})}, opts)
+	winners := make(map[int]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-spans:
+			winners[s.attempt] = s.winner
+		case <-time.After(1 * time.Second):
+			t.Fatalf("Expected 2 spans, got %d", i)
+		}
+	}
+	if winners[0] || !winners[1] {
+		t.Errorf("Expected attempt 1 tagged the winner, got %v", winners)
+	}
+}
+
+func TestTracerValidate(t *testing.T) {
+	spans := make(tracer, 6)
+	opts := Options{Tracer: spans, Validate: func(interface{}) bool { return false }}
+	_, err := RunOptions(context.TODO(), 0, 2, &str{"stale"}, opts)
+	if err != ErrNoValidResult {
+		t.Fatalf("Expected ErrNoValidResult, got %v", err)
+	}
+	settle(t)
+	ended := make(map[int]int)
+	for len(spans) > 0 {
+		ended[(<-spans).attempt]++
+	}
+	if len(ended) != 3 || ended[0] != 1 || ended[1] != 1 || ended[2] != 1 {
+		t.Errorf("Expected every span ended once, got %v", ended)
+	}
+}
+
+func TestRunAuto(t *testing.T) {
+	if v := RunAuto(context.TODO(), 0.5, 1, &str{"howdy"}); v != ErrNoDeadline {
+		t.Errorf("Expected ErrNoDeadline, got %v", v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	h := &hungOdds{make(chan struct{})}
+	start := time.Now()
+	v := RunAuto(ctx, 0.2, 1, h)
+	if v != 2 {
+		t.Errorf("Expected 2, got %v", v)
+	}
+	// The hedge goes out at a fifth of the 100ms remaining.
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Expected the hedge after about 20ms, got %v", elapsed)
+	}
+}
+
+// stuck returns a request whose original ignores cancellation until release
+// is closed, while its hedge succeeds.
+func stuck(release <-chan struct{}) Request {
+	return RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			<-release
+			return nil, nil
+		}
+		return "howdy", nil
+	})
+}
+
+// eventually polls cond until it holds or a second passes.
+func eventually(cond func() bool) bool {
+	for deadline := time.Now().Add(1 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return true
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	return cond()
+}
+
+// settle waits for the reapers of earlier tests to finish.
+func settle(t *testing.T) {
+	if !eventually(func() bool { return Reapers() == 0 }) {
+		t.Fatalf("Expected no reapers, got %d", Reapers())
+	}
+}
+
+func TestReapers(t *testing.T) {
+	settle(t)
+	before := Reapers()
+	release := make(chan struct{})
+	RunN(context.TODO(), 1*time.Millisecond, 1, stuck(release))
+	if n := Reapers(); n != before+1 {
+		t.Errorf("Expected %d reapers, got %d", before+1, n)
+	}
+	close(release)
+	if !eventually(func() bool { return Reapers() == before }) {
+		t.Errorf("Expected %d reapers, got %d", before, Reapers())
+	}
+}
+
+func TestReapTimeout(t *testing.T) {
+	settle(t)
+	before := Reapers()
+	release := make(chan struct{})
+	defer close(release)
+	opts := Options{ReapTimeout: 5 * time.Millisecond}
+	RunOptions(context.TODO(), 1*time.Millisecond, 1, stuck(release), opts)
+	if !eventually(func() bool { return Reapers() == before }) {
+		t.Errorf("Expected %d reapers, got %d", before, Reapers())
+	}
+}
+
+func TestResultBeatsCancel(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.TODO())
+		hedging := make(chan struct{})
+		completed := make(chan struct{})
+		opts := Options{
+			// Hold up the run while the original both completes and cancels
+			// the run, so that both are ready when it next looks.
+			OnSend: func(attempt int) {
+				if attempt == 1 {
+					close(hedging)
+					<-completed
+					time.Sleep(1 * time.Millisecond)
+				}
+			},
+			OnComplete: func(attempt int, err error, d time.Duration) {
+				if attempt == 0 {
+					close(completed)
+				}
+			},
+		}
+		r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+			if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+				<-hedging
+				cancel()
+				return "howdy", nil
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		v, err := RunOptions(ctx, 1*time.Millisecond, 1, r, opts)
+		if err != nil || v != "howdy" {
+			t.Fatalf("Expected howdy, got %v, %v", v, err)
+		}
+	}
+}
+
+func TestOnCompleteLosers(t *testing.T) {
+	type completion struct {
+		attempt int
+		err     error
+	}
+	completions := make(chan completion, 2)
+	opts := Options{
+		OnComplete: func(attempt int, err error, d time.Duration) {
+			completions <- completion{attempt, err}
+		},
+	}
+	release := make(chan struct{})
+	RunOptions(context.TODO(), 1*time.Millisecond, 1, stuck(release), opts)
+	// The winner reported before the run returned.
+	if c := <-completions; c.attempt != 1 || c.err != nil {
+		t.Errorf("Expected attempt 1 to complete first, got %+v", c)
+	}
+	// The loser reports once it returns, after the run did.
+	close(release)
+	select {
+	case c := <-completions:
+		if c.attempt != 0 {
+			t.Errorf("Expected attempt 0 to complete, got %+v", c)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("Expected the loser to complete")
+	}
+}
+
+func TestRetryOn(t *testing.T) {
+	opts := Options{RetryOn: func(err error) bool { return err == errHowdy }}
+	start := time.Now()
+	v, err := RunOptions(context.TODO(), 1*time.Hour, 1, &fastFailure{}, opts)
+	if err != nil || v != "howdy" {
+		t.Errorf("Expected howdy, got %v, %v", v, err)
+	}
+	if time.Since(start) > 1*time.Second {
+		t.Error("Expected the hedge sent right away")
+	}
+}
+
+func TestRetryOnOtherError(t *testing.T) {
+	opts := Options{RetryOn: func(err error) bool { return false }}
+	_, err := RunOptions(context.TODO(), 1*time.Hour, 1, &fastFailure{}, opts)
+	if err != errHowdy {
+		t.Errorf("Expected errHowdy, got %v", err)
+	}
+}
+
+func TestFailFastQuorum(t *testing.T) {
+	reasons := make(chan error, 4)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 1 || attempt == 2 {
+			return nil, errHowdy
+		}
+		<-ctx.Done()
+		reasons <- Reason(ctx)
+		return nil, ctx.Err()
+	})
+	opts := Options{RetryOn: func(error) bool { return true }, FailFastQuorum: 2}
+	_, err := RunOptions(context.TODO(), 0, 5, r, opts)
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 || errs[0] != nil || errs[1] != errHowdy || errs[2] != errHowdy {
+		t.Fatalf("Expected the 2 errors, got %v", err)
+	}
+	// The original, still in flight, didn't fail.
+	if want := "hedged: 2 requests failed; attempt 1: howdy; attempt 2: howdy"; err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+	for i := 0; i < 4; i++ {
+		if reason := <-reasons; reason != ErrFailFast {
+			t.Errorf("Expected ErrFailFast, got %v", reason)
+		}
+	}
+
+	// Failing one by one, the hedges past the quorum aren't sent.
+	var calls int32
+	_, err = RunOptions(context.TODO(), 1*time.Millisecond, 5, RequestFunc(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errHowdy
+	}), Options{RetryOn: func(error) bool { return true }, FailFastQuorum: 3})
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Errorf("Expected 3 errors, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("Expected 3 requests sent, got %d", n)
+	}
+}
+
+func BenchmarkRunN500(b *testing.B) {
+	ctx := context.TODO()
+	s := &str{"howdy"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		RunN(ctx, 1*time.Second, 500, s)
+	}
+}
+
+func TestReapDrainsLargeN(t *testing.T) {
+	const n = 50
+	discarded := make(chan interface{}, n)
+	opts := Options{Discard: func(v interface{}) { discarded <- v }}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt < n {
+			<-ctx.Done()
+			return "loser", nil
+		}
+		return "winner", nil
+	})
+	v, err := RunOptions(context.TODO(), 0, n, r, opts)
+	if err != nil || v != "winner" {
+		t.Fatalf("Expected winner, got %v, %v", v, err)
+	}
+	// Far more losers than the buffer holds, all of them drained.
+	for i := 0; i < n; i++ {
+		select {
+		case <-discarded:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("Expected %d losers discarded, got %d", n, i)
+		}
+	}
+}
+
+func TestReapTimeoutAbandons(t *testing.T) {
+	before := runtime.NumGoroutine()
+	release := make(chan struct{})
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt < 20 {
+			<-release
+			return "loser", nil
+		}
+		return "winner", nil
+	})
+	opts := Options{ReapTimeout: 1 * time.Millisecond}
+	RunOptions(context.TODO(), 0, 20, r, opts)
+	settle(t)
+	// With nobody left to receive, the losers must still exit.
+	close(release)
+	if !eventually(func() bool { return runtime.NumGoroutine() <= before }) {
+		t.Errorf("Expected %d goroutines, got %d", before, runtime.NumGoroutine())
+	}
+}
+
+func TestLateSendStress(t *testing.T) {
+	var sent, discarded int64
+	opts := Options{
+		OnSend:  func(int) { atomic.AddInt64(&sent, 1) },
+		Discard: func(interface{}) { atomic.AddInt64(&discarded, 1) },
+	}
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		time.Sleep(time.Duration(rand.Intn(50)) * time.Microsecond)
+		return attempt, nil
+	})
+	const runs, n = 200, 10
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := RunOptions(context.TODO(), 10*time.Microsecond, n, r, opts); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	settle(t)
+	// Every result but the winner's gets discarded, however late it lands.
+	if s, d := atomic.LoadInt64(&sent), atomic.LoadInt64(&discarded); d != s-runs {
+		t.Errorf("Expected %d discarded, got %d", s-runs, d)
+	}
+}
+
+func BenchmarkRunNInstant(b *testing.B) {
+	ctx := context.TODO()
+	s := &str{"howdy"}
+	for _, n := range []int{0, 1} {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				RunN(ctx, 1*time.Second, n, s)
+			}
+		})
+	}
+}
+
+// landingClock delivers the hedge tick only once the original request has
+// completed, so that both are ready at once.
+type landingClock chan struct{}
+
+func (c landingClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c landingClock) After(d time.Duration) <-chan time.Time {
+	<-c
+	// Leave time for the result to be sent.
+	time.Sleep(1 * time.Millisecond)
+	ch := make(chan time.Time, 1)
+	ch <- time.Now()
+	return ch
+}
+
+func BenchmarkHedgeAtWait(b *testing.B) {
+	var hedges int64
+	for i := 0; i < b.N; i++ {
+		completed := make(landingClock)
+		opts := Options{
+			Clock: completed,
+			OnSend: func(attempt int) {
+				if attempt > 0 {
+					atomic.AddInt64(&hedges, 1)
+				}
+			},
+			OnComplete: func(attempt int, err error, d time.Duration) {
+				if attempt == 0 {
+					close(completed)
+				}
+			},
+		}
+		RunOptions(context.TODO(), 1*time.Second, 1, &str{"howdy"}, opts)
+	}
+	b.ReportMetric(float64(hedges)/float64(b.N), "hedges/op")
+}
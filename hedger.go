@@ -0,0 +1,424 @@
+package hedged
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWindow is the number of latencies a Hedger keeps when Window is zero.
+const DefaultWindow = 1000
+
+// minSamples is how many latencies a Hedger needs before trusting them over
+// Wait.
+const minSamples = 10
+
+// Hedger runs requests with a reusable configuration, e.g. one per backend. It
+// is created by New, or as a struct literal.
+//
+// If Percentile is set, it hedges at that percentile of the latencies it has
+// observed rather than at a fixed wait, so that only the slowest requests get
+// hedged. It then records the duration of every request that completes,
+// across calls to Run, keeping the most recent in a rolling window.
+//
+// A Hedger is safe for concurrent use, but its fields must not change once
+// in use.
+type Hedger struct {
+	// Name, if set, labels the Hedger, e.g. by backend, where many are in
+	// use: it is reported by Metrics, added as the attribute "hedger" to
+	// what Options.Logger logs, and returned by NameFromContext to the
+	// requests it runs.
+	Name string
+
+	// Wait is the interval at which hedge requests get sent, or, if
+	// Percentile is set, the interval used until enough latencies are
+	// recorded.
+	Wait time.Duration
+
+	// N is the number of hedge requests, as in RunN. Zero means 1.
+	N int
+
+	// Percentile, in (0, 1], is the percentile of recorded latencies to hedge
+	// at, e.g. 0.95. Zero always hedges at Wait.
+	Percentile float64
+
+	// Window is the number of recent latencies to keep. Zero means
+	// DefaultWindow.
+	Window int
+
+	// MaxCostRatio, if positive, caps the hedges sent at this fraction of the
+	// calls to Run, e.g. 0.05 for at most 5% extra work, as in "The Tail at
+	// Scale". Once the hedges sent so far reach it, further hedges are
+	// suppressed until enough calls have been made without. Concurrent calls
+	// may overshoot it slightly.
+	MaxCostRatio float64
+
+	// Options customize how requests are run.
+	Options Options
+
+	// Recorder, if set, records the latency of every run, with and without
+	// hedging, to measure what hedging saves.
+	Recorder *Recorder
+
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+
+	// The counts for Metrics; calls and hedges also feed MaxCostRatio.
+	calls, hedges, hedgedRuns, hedgeWins int64
+
+	// keyed holds the runs in flight for RunKeyed, by key.
+	keyedMu sync.Mutex
+	keyed   map[string]*keyedRun
+}
+
+// New returns a Hedger configured by opts. Without options, it hedges once,
+// right away.
+func New(opts ...Option) *Hedger {
+	h := &Hedger{}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Run sends the request as configured, and returns the value and error of the
+// winning request.
+func (h *Hedger) Run(ctx context.Context, r Request) (interface{}, error) {
+	return h.RunWith(ctx, r, Override{})
+}
+
+// Override holds per-call values overlaid on a Hedger's configuration by
+// RunWith. Zero fields keep the configured value.
+type Override struct {
+	// Wait replaces the hedge delay, adaptive or not.
+	Wait time.Duration
+
+	// N replaces the number of hedge requests.
+	N int
+}
+
+// RunWith is like Run but with the values set in ov in place of the
+// configured ones, e.g. to hedge harder on a critical call.
+func (h *Hedger) RunWith(ctx context.Context, r Request, ov Override) (interface{}, error) {
+	res := h.run(ctx, r, ov, h.Options)
+	return res.Value, res.Err
+}
+
+// RunCancelable is like Run but runs in the background, returning at once a
+// channel that receives the result, and is then closed, and a cancel func to
+// abort the run from elsewhere, e.g. once another goroutine decides its
+// result is no longer needed. Cancelling cancels every request in flight, and
+// the result is then context.Canceled, unless a request won first. Resources
+// are held until either the result is sent or cancel is called; cancel may be
+// called more than once.
+func (h *Hedger) RunCancelable(ctx context.Context, r Request) (<-chan Result, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	ch := make(chan Result, 1)
+	go func() {
+		defer cancel()
+		ch <- h.run(ctx, r, Override{}, h.Options).Result
+		close(ch)
+	}()
+	return ch, cancel
+}
+
+// RunKeyed is like Run but coalesces concurrent calls with the same key, as
+// singleflight does: a call made while another with its key is in flight
+// joins it rather than sending requests of its own, and every call that
+// joined gets the same value and error. r is only used by the call that
+// starts the run.
+//
+// A call that finds its ctx done leaves, returning ctx.Err(), without
+// cancelling the shared run, which is only cancelled once every call has
+// left. The run gets the values of the ctx of the call that started it, but
+// not its deadline or cancellation.
+func (h *Hedger) RunKeyed(ctx context.Context, key string, r Request) (interface{}, error) {
+	h.keyedMu.Lock()
+	k, ok := h.keyed[key]
+	if !ok {
+		if h.keyed == nil {
+			h.keyed = make(map[string]*keyedRun)
+		}
+		shared, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		k = &keyedRun{done: make(chan struct{}), cancel: cancel}
+		h.keyed[key] = k
+		go func() {
+			res := h.run(shared, r, Override{}, h.Options).Result
+			h.keyedMu.Lock()
+			k.res = res
+			h.forget(key, k)
+			h.keyedMu.Unlock()
+			cancel()
+			close(k.done)
+		}()
+	}
+	k.joined++
+	h.keyedMu.Unlock()
+
+	select {
+	case <-k.done:
+		return k.res.Value, k.res.Err
+	case <-ctx.Done():
+		h.keyedMu.Lock()
+		if k.joined--; k.joined == 0 {
+			// The last to leave: no one is left to want the result.
+			k.cancel()
+			h.forget(key, k)
+		}
+		h.keyedMu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// RunTracked is like RunCancelable, without the cancel, but also returns a
+// RunHandle telling the state of the run as it goes, e.g. to diagnose a run
+// that seems stuck.
+func (h *Hedger) RunTracked(ctx context.Context, r Request) (*RunHandle, <-chan Result) {
+	o := h.Options
+	t := &RunHandle{now: o.now, start: o.now()}
+	onSend, onComplete := o.OnSend, o.OnComplete
+	o.OnSend = func(attempt int) {
+		t.mu.Lock()
+		t.state.Sent++
+		t.mu.Unlock()
+		if onSend != nil {
+			onSend(attempt)
+		}
+	}
+	o.OnComplete = func(attempt int, err error, d time.Duration) {
+		t.mu.Lock()
+		t.state.Completed++
+		t.mu.Unlock()
+		if onComplete != nil {
+			onComplete(attempt, err, d)
+		}
+	}
+	ch := make(chan Result, 1)
+	go func() {
+		res := h.run(ctx, r, Override{}, o).Result
+		t.mu.Lock()
+		t.state.Done = true
+		t.state.Elapsed = o.now().Sub(t.start)
+		t.mu.Unlock()
+		ch <- res
+		close(ch)
+	}()
+	return t, ch
+}
+
+// RunHandle tells the state of a run started by RunTracked.
+type RunHandle struct {
+	now   func() time.Time
+	start time.Time
+
+	mu    sync.Mutex
+	state RunState
+}
+
+// RunState is the state of a run at some moment.
+type RunState struct {
+	// Sent is the number of requests sent so far, the original included.
+	Sent int
+
+	// Completed is the number of requests that have returned, losers
+	// included, which may keep returning after the run.
+	Completed int
+
+	// Elapsed is the time since the run started, or, once done, how long
+	// it took.
+	Elapsed time.Duration
+
+	// Done is set once the run has returned its result.
+	Done bool
+}
+
+// Snapshot returns the state of the run, consistent as of a single moment.
+// It is safe to call concurrently with the run.
+func (t *RunHandle) Snapshot() RunState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.state
+	if !state.Done {
+		state.Elapsed = t.now().Sub(t.start)
+	}
+	return state
+}
+
+// keyedRun is a run shared by the calls to RunKeyed with its key.
+type keyedRun struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	// joined counts the calls waiting on it; guarded by Hedger.keyedMu, as
+	// is res until done is closed.
+	joined int
+	res    Result
+}
+
+// forget removes k from the runs in flight, unless replaced already by a run
+// started since every call left it. Called with h.keyedMu held.
+func (h *Hedger) forget(key string, k *keyedRun) {
+	if h.keyed[key] == k {
+		delete(h.keyed, key)
+	}
+}
+
+// run runs the request as configured, with ov overlaid, and o in place of
+// h.Options.
+func (h *Hedger) run(ctx context.Context, r Request, ov Override, o Options) result {
+	o.name = h.Name
+	if h.Percentile > 0 {
+		onComplete := o.OnComplete
+		o.OnComplete = func(attempt int, err error, d time.Duration) {
+			// A cancelled request says nothing about how long it would have
+			// taken.
+			if !errors.Is(err, context.Canceled) {
+				h.record(d)
+			}
+			if onComplete != nil {
+				onComplete(attempt, err, d)
+			}
+		}
+	}
+	atomic.AddInt64(&h.calls, 1)
+	// OnSend is called from the run's own goroutine, as is the code after
+	// it returns.
+	hedged := false
+	onSend := o.OnSend
+	o.OnSend = func(attempt int) {
+		if attempt > 0 {
+			hedged = true
+			atomic.AddInt64(&h.hedges, 1)
+		}
+		if onSend != nil {
+			onSend(attempt)
+		}
+	}
+	if h.MaxCostRatio > 0 {
+		shouldHedge := o.ShouldHedge
+		o.ShouldHedge = func() bool {
+			if !h.underBudget() {
+				return false
+			}
+			return shouldHedge == nil || shouldHedge()
+		}
+	}
+	n := h.N
+	if ov.N != 0 {
+		n = ov.N
+	}
+	if n == 0 {
+		n = 1
+	}
+	wait := ov.Wait
+	if wait == 0 {
+		wait = h.wait()
+	}
+	if h.Recorder != nil {
+		o.stats = new(Stats)
+	}
+	res := run(ctx, wait, n, r, &o)
+	if h.Recorder != nil {
+		h.Recorder.record(o.stats)
+	}
+	if hedged {
+		atomic.AddInt64(&h.hedgedRuns, 1)
+	}
+	if res.Attempt > 0 {
+		atomic.AddInt64(&h.hedgeWins, 1)
+	}
+	return res
+}
+
+// Metrics counts the runs of a Hedger since it was created, e.g. to tune Wait:
+// HedgedRuns/Runs is the rate at which hedges fire, and HedgeWins/HedgedRuns
+// the rate at which a fired hedge pays off.
+type Metrics struct {
+	// Name is the Name of the Hedger.
+	Name string
+
+	// Runs is the number of calls to run a request.
+	Runs int64
+
+	// Hedges is the number of hedges sent, across all runs.
+	Hedges int64
+
+	// HedgedRuns is the number of runs that sent at least one hedge.
+	HedgedRuns int64
+
+	// HedgeWins is the number of runs won by a hedge rather than the
+	// original.
+	HedgeWins int64
+}
+
+// Metrics returns the counts so far. Each is read atomically, though not all
+// at the same instant, so runs in flight may show in some and not others.
+func (h *Hedger) Metrics() Metrics {
+	return Metrics{
+		Name:       h.Name,
+		Runs:       atomic.LoadInt64(&h.calls),
+		Hedges:     atomic.LoadInt64(&h.hedges),
+		HedgedRuns: atomic.LoadInt64(&h.hedgedRuns),
+		HedgeWins:  atomic.LoadInt64(&h.hedgeWins),
+	}
+}
+
+// record adds a latency to the window, evicting the oldest if full.
+func (h *Hedger) record(d time.Duration) {
+	window := h.Window
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < window {
+		h.samples = append(h.samples, d)
+		return
+	}
+	h.samples[h.next] = d
+	h.next = (h.next + 1) % len(h.samples)
+}
+
+// underBudget reports whether another hedge keeps within MaxCostRatio.
+func (h *Hedger) underBudget() bool {
+	calls, hedges := atomic.LoadInt64(&h.calls), atomic.LoadInt64(&h.hedges)
+	return float64(hedges+1) <= h.MaxCostRatio*float64(calls)
+}
+
+// wait returns the hedge delay: the configured percentile of recorded
+// latencies, or Wait if unset or too few are recorded.
+func (h *Hedger) wait() time.Duration {
+	p := h.Percentile
+	if p <= 0 {
+		return h.Wait
+	}
+	h.mu.Lock()
+	if len(h.samples) < minSamples {
+		h.mu.Unlock()
+		return h.Wait
+	}
+	samples := append([]time.Duration(nil), h.samples...)
+	h.mu.Unlock()
+	return percentile(samples, p)
+}
+
+// percentile returns the p percentile of samples, which it sorts, or zero if
+// there are none.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(math.Ceil(p*float64(len(samples)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(samples) {
+		i = len(samples) - 1
+	}
+	return samples[i]
+}
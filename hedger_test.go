@@ -0,0 +1,307 @@
+package hedged
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgerWait(t *testing.T) {
+	h := &Hedger{Wait: 1 * time.Second, Percentile: 0.95}
+	if d := h.wait(); d != 1*time.Second {
+		t.Errorf("Expected Wait before recording, got %v", d)
+	}
+	for i := 100; i > 0; i-- {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	if d := h.wait(); d != 95*time.Millisecond {
+		t.Errorf("Expected 95ms, got %v", d)
+	}
+}
+
+func TestHedgerWindow(t *testing.T) {
+	h := &Hedger{Percentile: 1, Window: 10}
+	for i := 1; i <= 20; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	// Only the 10 most recent, 11ms through 20ms, remain.
+	if len(h.samples) != 10 {
+		t.Errorf("Expected 10 samples, got %d", len(h.samples))
+	}
+	if d := h.wait(); d != 20*time.Millisecond {
+		t.Errorf("Expected 20ms, got %v", d)
+	}
+	h.Percentile = 0.1
+	if d := h.wait(); d != 11*time.Millisecond {
+		t.Errorf("Expected 11ms, got %v", d)
+	}
+}
+
+func TestHedgerRun(t *testing.T) {
+	h := &Hedger{Wait: 10 * time.Second, Percentile: 0.95}
+	for i := 0; i < minSamples; i++ {
+		v, err := h.Run(context.TODO(), &str{"howdy"})
+		if err != nil || v != "howdy" {
+			t.Fatalf("Expected howdy, got %v, %v", v, err)
+		}
+	}
+	// Each run records its request's latency, eventually replacing Wait.
+	if d := h.wait(); d >= 10*time.Second {
+		t.Errorf("Expected recorded latency, got %v", d)
+	}
+}
+
+func TestHedgerFixedWait(t *testing.T) {
+	h := &Hedger{Wait: 10 * time.Second}
+	for i := 0; i < minSamples; i++ {
+		h.Run(context.TODO(), &str{"howdy"})
+	}
+	if d := h.wait(); d != 10*time.Second {
+		t.Errorf("Expected Wait without Percentile, got %v", d)
+	}
+}
+
+func TestNew(t *testing.T) {
+	var sends int32
+	h := New(
+		WithWait(1*time.Millisecond),
+		WithN(3),
+		WithOnSend(func(int) { atomic.AddInt32(&sends, 1) }),
+	)
+	if h.Wait != 1*time.Millisecond || h.N != 3 {
+		t.Errorf("Expected 1ms and 3 hedges, got %v and %d", h.Wait, h.N)
+	}
+	v, err := h.Run(context.TODO(), &counting{last: 4})
+	if err != nil || v != int32(4) {
+		t.Errorf("Expected 4, got %v, %v", v, err)
+	}
+	if sends != 4 {
+		t.Errorf("Expected 4 sends, got %d", sends)
+	}
+}
+
+func TestHedgerRunWith(t *testing.T) {
+	var sends int32
+	h := New(
+		WithWait(1*time.Hour),
+		WithOnSend(func(int) { atomic.AddInt32(&sends, 1) }),
+	)
+	v, err := h.RunWith(context.TODO(), &counting{last: 3}, Override{Wait: 1 * time.Millisecond, N: 2})
+	if err != nil || v != int32(3) {
+		t.Errorf("Expected 3, got %v, %v", v, err)
+	}
+	if sends != 3 {
+		t.Errorf("Expected 3 sends, got %d", sends)
+	}
+}
+
+func TestHedgerMaxCostRatio(t *testing.T) {
+	var hedges int32
+	h := New(
+		WithMaxCostRatio(0.25),
+		WithOnSend(func(attempt int) {
+			if attempt > 0 {
+				atomic.AddInt32(&hedges, 1)
+			}
+		}),
+	)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			time.Sleep(1 * time.Millisecond)
+		}
+		return "howdy", nil
+	})
+	for i := 0; i < 100; i++ {
+		if v, err := h.Run(context.TODO(), r); v != "howdy" || err != nil {
+			t.Fatalf("Expected howdy, got %v, %v", v, err)
+		}
+	}
+	// Every fourth call may hedge.
+	if hedges != 25 {
+		t.Errorf("Expected 25 hedges, got %d", hedges)
+	}
+}
+
+func TestHedgerFullJitter(t *testing.T) {
+	a := New(WithFullJitter(rand.NewSource(42)))
+	b := New(WithFullJitter(rand.NewSource(42)))
+	base := 1 * time.Second
+	for i := 0; i < 10; i++ {
+		da, db := a.Options.Jitter(base), b.Options.Jitter(base)
+		if da != db {
+			t.Fatalf("Expected the same jitter from the same seed, got %v and %v", da, db)
+		}
+		if da < 0 || da >= base {
+			t.Fatalf("Expected jitter in [0, %v), got %v", base, da)
+		}
+	}
+}
+
+func TestHedgerFullJitterSeeded(t *testing.T) {
+	h := New(WithFullJitter(nil))
+	base := 1 * time.Second
+	for i := 0; i < 10; i++ {
+		if d := h.Options.Jitter(base); d < 0 || d >= base {
+			t.Fatalf("Expected jitter in [0, %v), got %v", base, d)
+		}
+	}
+}
+
+func TestHedgerRunCancelable(t *testing.T) {
+	h := New(WithWait(1 * time.Millisecond))
+	c := &counting{}
+	ch, cancel := h.RunCancelable(context.TODO(), c)
+	eventually(func() bool { return atomic.LoadInt32(&c.calls) == 2 })
+	cancel()
+	res, ok := <-ch
+	if !ok || res.Err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %+v", res)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("Expected channel closed")
+	}
+
+	ch, cancel = h.RunCancelable(context.TODO(), &str{"howdy"})
+	defer cancel()
+	if res := <-ch; res.Value != "howdy" {
+		t.Errorf("Expected howdy, got %+v", res)
+	}
+}
+
+func TestHedgerMetrics(t *testing.T) {
+	h := New(WithWait(1*time.Millisecond), WithN(2))
+	h.Run(context.TODO(), &str{"howdy"})
+	h.Run(context.TODO(), &counting{last: 2})
+	h.Run(context.TODO(), &counting{last: 3})
+	want := Metrics{Runs: 3, Hedges: 3, HedgedRuns: 2, HedgeWins: 2}
+	if m := h.Metrics(); m != want {
+		t.Errorf("Expected %+v, got %+v", want, m)
+	}
+}
+
+func TestHedgerName(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	h := New(WithName("users"), WithLogger(logger))
+	names := make(chan string, 2)
+	h.Run(context.TODO(), RequestFunc(func(ctx context.Context) (interface{}, error) {
+		name, _ := NameFromContext(ctx)
+		names <- name
+		return "howdy", nil
+	}))
+	if name := <-names; name != "users" {
+		t.Errorf("Expected users from the context, got %q", name)
+	}
+	if m := h.Metrics(); m.Name != "users" {
+		t.Errorf("Expected users in the metrics, got %q", m.Name)
+	}
+	if !strings.Contains(buf.String(), "hedged: request won") || strings.Count(buf.String(), "hedger=users") != strings.Count(buf.String(), "\n") {
+		t.Errorf("Expected hedger=users on every record, got\n%s", buf.String())
+	}
+	if _, ok := NameFromContext(context.TODO()); ok {
+		t.Error("Expected no name outside a Hedger")
+	}
+}
+
+func TestHedgerRunKeyed(t *testing.T) {
+	h := New(WithWait(1 * time.Second))
+	var calls int32
+	release := make(chan struct{})
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "howdy", nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := h.RunKeyed(context.TODO(), "key", r); err != nil || v != "howdy" {
+				t.Errorf("Expected howdy, got %v, %v", v, err)
+			}
+		}()
+	}
+	eventually(func() bool {
+		h.keyedMu.Lock()
+		defer h.keyedMu.Unlock()
+		return h.keyed["key"] != nil && h.keyed["key"].joined == 5
+	})
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+	}
+	if len(h.keyed) != 0 {
+		t.Errorf("Expected no runs in flight, got %d", len(h.keyed))
+	}
+}
+
+func TestHedgerRunKeyedLeave(t *testing.T) {
+	h := New(WithWait(1 * time.Second))
+	shared := make(chan context.Context, 1)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		shared <- ctx
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	ctx1, cancel1 := context.WithCancel(context.TODO())
+	ctx2, cancel2 := context.WithCancel(context.TODO())
+	errs := make(chan error, 2)
+	go func() { _, err := h.RunKeyed(ctx1, "key", r); errs <- err }()
+	ctx := <-shared
+	go func() { _, err := h.RunKeyed(ctx2, "key", r); errs <- err }()
+	eventually(func() bool {
+		h.keyedMu.Lock()
+		defer h.keyedMu.Unlock()
+		return h.keyed["key"].joined == 2
+	})
+
+	// The starter leaving leaves the run to the other call.
+	cancel1()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("Expected the shared run alive while a call is joined")
+	}
+	cancel2()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	<-ctx.Done()
+}
+
+func TestHedgerRunTracked(t *testing.T) {
+	h := New(WithWait(1*time.Millisecond), WithN(2))
+	release := make(chan struct{})
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		// Stuck until released, ignoring cancellation.
+		<-release
+		attempt, _ := AttemptFromContext(ctx)
+		return attempt, nil
+	})
+	handle, ch := h.RunTracked(context.TODO(), r)
+	eventually(func() bool { return handle.Snapshot().Sent == 3 })
+	if state := handle.Snapshot(); state.Sent != 3 || state.Completed != 0 || state.Done || state.Elapsed <= 0 {
+		t.Errorf("Expected 3 sent, none completed, got %+v", state)
+	}
+	close(release)
+	<-ch
+	eventually(func() bool { return handle.Snapshot().Completed == 3 })
+	state := handle.Snapshot()
+	if state.Sent != 3 || state.Completed != 3 || !state.Done {
+		t.Errorf("Expected 3 sent and completed, and done, got %+v", state)
+	}
+	time.Sleep(1 * time.Millisecond)
+	if again := handle.Snapshot(); again.Elapsed != state.Elapsed {
+		t.Errorf("Expected the elapsed time fixed once done, got %v then %v", state.Elapsed, again.Elapsed)
+	}
+}
@@ -0,0 +1,134 @@
+package hedged
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrNilResponse is returned by Transport when its Base returns neither a
+// response nor an error.
+var ErrNilResponse = errors.New("hedged: nil response from RoundTripper")
+
+// DiscardHTTPResponse drains and closes the body of v if it is an
+// *http.Response, so that its connection can return to the pool. It is meant
+// for use as Options.Discard when requests are sent with an http.Client.
+func DiscardHTTPResponse(v interface{}) {
+	resp, ok := v.(*http.Response)
+	if !ok || resp == nil || resp.Body == nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// Transport is an http.RoundTripper that hedges every request it sends, so
+// that an http.Client can hedge without changes at its call sites.
+//
+// Each hedge sends the request body afresh, as prepared by BufferBody. The
+// bodies of losing responses are drained and closed. The winning response's
+// request stays alive until its body is closed.
+type Transport struct {
+	// Wait is the interval at which hedge requests get sent.
+	Wait time.Duration
+
+	// N is the number of hedge requests, as in RunN. Zero means 1.
+	N int
+
+	// Base sends each request. Nil means http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	newReq, err := BufferBody(req)
+	if err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	n := t.N
+	if n == 0 {
+		n = 1
+	}
+
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		return base.RoundTrip(newReq().WithContext(ctx))
+	})
+	res := run(req.Context(), t.Wait, n, r, &Options{
+		Discard:    DiscardHTTPResponse,
+		keepWinner: true,
+	})
+	if res.Err != nil {
+		if res.release != nil {
+			res.release()
+		}
+		return nil, res.Err
+	}
+	resp, _ := res.Value.(*http.Response)
+	if resp == nil {
+		if res.release != nil {
+			res.release()
+		}
+		return nil, ErrNilResponse
+	}
+	resp.Body = &releaseBody{resp.Body, res.release}
+	return resp, nil
+}
+
+// BufferBody prepares req to be sent several times, e.g. by hedge requests. It
+// returns a function producing clones of req, each with a fresh body.
+//
+// If req.GetBody is set, it is used to get each fresh body. Otherwise the body
+// is read into memory, and closed, once; this suits small payloads only.
+func BufferBody(req *http.Request) (func() *http.Request, error) {
+	getBody := req.GetBody
+	if getBody == nil && req.Body != nil && req.Body != http.NoBody {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		getBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+	}
+	return func() *http.Request {
+		clone := req.Clone(req.Context())
+		if getBody != nil {
+			body, err := getBody()
+			if err != nil {
+				body = io.NopCloser(errReader{err})
+			}
+			clone.Body = body
+			clone.GetBody = getBody
+		}
+		return clone
+	}, nil
+}
+
+// errReader fails every read with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// releaseBody releases the winning request when its response body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,152 @@
+package hedged
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type body struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (b *body) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func newResponse() *http.Response {
+	return &http.Response{
+		StatusCode: 200,
+		Body:       &body{strings.NewReader("howdy"), make(chan struct{})},
+	}
+}
+
+func TestDiscardHTTPResponse(t *testing.T) {
+	winner, loser := newResponse(), newResponse()
+	var calls int32
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// Finish only once the hedge has won.
+			<-ctx.Done()
+			return loser, nil
+		}
+		return winner, nil
+	})
+	opts := Options{Discard: DiscardHTTPResponse}
+	v, err := RunOptions(context.TODO(), 1*time.Millisecond, 1, r, opts)
+	if err != nil || v != winner {
+		t.Fatalf("Expected winner, got %v, %v", v, err)
+	}
+	select {
+	case <-loser.Body.(*body).closed:
+	case <-time.After(1 * time.Second):
+		t.Error("Losing response body not closed")
+	}
+	select {
+	case <-winner.Body.(*body).closed:
+		t.Error("Winning response body closed")
+	default:
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var calls int32
+	cancelled := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Consuming the body lets the server notice a cancelled client.
+		b, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&calls, 1)%2 != 0 {
+			select {
+			case <-r.Context().Done():
+				cancelled <- struct{}{}
+			case <-time.After(1 * time.Second):
+			}
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{Wait: 10 * time.Millisecond}}
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("howdy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(b) != "howdy" {
+		t.Errorf("Expected howdy, got %q, %v", b, err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(1 * time.Second):
+		t.Error("Slow request not cancelled")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransportNilResponse(t *testing.T) {
+	base := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, nil
+	})
+	tr := &Transport{Wait: 0, Base: base}
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	if resp, err := tr.RoundTrip(req); resp != nil || err != ErrNilResponse {
+		t.Errorf("Expected ErrNilResponse, got %v, %v", resp, err)
+	}
+}
+
+func TestBufferBody(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://example.com", io.NopCloser(strings.NewReader("howdy")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newReq, err := BufferBody(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		b, err := io.ReadAll(newReq().Body)
+		if err != nil || string(b) != "howdy" {
+			t.Errorf("Clone %d: Expected howdy, got %q, %v", i, b, err)
+		}
+	}
+}
+
+func TestBufferBodyGetBody(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://example.com", strings.NewReader("howdy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gets int
+	getBody := req.GetBody
+	req.GetBody = func() (io.ReadCloser, error) {
+		gets++
+		return getBody()
+	}
+	newReq, err := BufferBody(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		b, err := io.ReadAll(newReq().Body)
+		if err != nil || string(b) != "howdy" {
+			t.Errorf("Clone %d: Expected howdy, got %q, %v", i, b, err)
+		}
+	}
+	if gets != 2 {
+		t.Errorf("Expected GetBody called twice, got %d", gets)
+	}
+}
@@ -0,0 +1,226 @@
+package hedged
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Option configures a Hedger created by New.
+type Option func(*Hedger)
+
+// WithName sets Hedger.Name.
+func WithName(name string) Option {
+	return func(h *Hedger) { h.Name = name }
+}
+
+// WithWait sets Hedger.Wait.
+func WithWait(d time.Duration) Option {
+	return func(h *Hedger) { h.Wait = d }
+}
+
+// WithN sets Hedger.N.
+func WithN(n int) Option {
+	return func(h *Hedger) { h.N = n }
+}
+
+// WithPercentile sets Hedger.Percentile, hedging at that percentile of
+// recorded latencies.
+func WithPercentile(p float64) Option {
+	return func(h *Hedger) { h.Percentile = p }
+}
+
+// WithWindow sets Hedger.Window.
+func WithWindow(n int) Option {
+	return func(h *Hedger) { h.Window = n }
+}
+
+// WithMaxCostRatio sets Hedger.MaxCostRatio.
+func WithMaxCostRatio(ratio float64) Option {
+	return func(h *Hedger) { h.MaxCostRatio = ratio }
+}
+
+// WithRecorder sets Hedger.Recorder.
+func WithRecorder(r *Recorder) Option {
+	return func(h *Hedger) { h.Recorder = r }
+}
+
+// WithOptions replaces all of Hedger.Options. Options given after it apply
+// on top.
+func WithOptions(o Options) Option {
+	return func(h *Hedger) { h.Options = o }
+}
+
+// WithDiscard sets Options.Discard.
+func WithDiscard(f func(interface{})) Option {
+	return func(h *Hedger) { h.Options.Discard = f }
+}
+
+// WithAutoCloseLosers sets Options.AutoCloseLosers.
+func WithAutoCloseLosers() Option {
+	return func(h *Hedger) { h.Options.AutoCloseLosers = true }
+}
+
+// WithCleanup sets Options.Cleanup.
+func WithCleanup(f func(attempt int, v interface{}, err error)) Option {
+	return func(h *Hedger) { h.Options.Cleanup = f }
+}
+
+// WithLogger sets Options.Logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(h *Hedger) { h.Options.Logger = l }
+}
+
+// WithKeepLosers sets Options.KeepLosers.
+func WithKeepLosers() Option {
+	return func(h *Hedger) { h.Options.KeepLosers = true }
+}
+
+// WithOnSend sets Options.OnSend.
+func WithOnSend(f func(attempt int)) Option {
+	return func(h *Hedger) { h.Options.OnSend = f }
+}
+
+// WithOnComplete sets Options.OnComplete.
+func WithOnComplete(f func(attempt int, err error, d time.Duration)) Option {
+	return func(h *Hedger) { h.Options.OnComplete = f }
+}
+
+// WithOnSavings sets Options.OnSavings.
+func WithOnSavings(f func(saved time.Duration, hedged bool)) Option {
+	return func(h *Hedger) { h.Options.OnSavings = f }
+}
+
+// WithTimeout sets Options.Timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(h *Hedger) { h.Options.Timeout = d }
+}
+
+// WithAttemptTimeout sets Options.AttemptTimeout.
+func WithAttemptTimeout(d time.Duration) Option {
+	return func(h *Hedger) { h.Options.AttemptTimeout = d }
+}
+
+// WithJitter sets Options.Jitter.
+func WithJitter(f func(base time.Duration) time.Duration) Option {
+	return func(h *Hedger) { h.Options.Jitter = f }
+}
+
+// WithFullJitter sets Options.Jitter to a jitter from NewFullJitter, so that
+// the Hedger draws from its own generator seeded by src. A nil src seeds it
+// from the time, for a Hedger that needs no reproducible jitter.
+func WithFullJitter(src rand.Source) Option {
+	return func(h *Hedger) { h.Options.Jitter = NewFullJitter(src) }
+}
+
+// WithBackoff sets Options.Backoff.
+func WithBackoff(f func(attempt int, base time.Duration) time.Duration) Option {
+	return func(h *Hedger) { h.Options.Backoff = f }
+}
+
+// WithInitialDelay sets Options.InitialDelay.
+func WithInitialDelay(d time.Duration) Option {
+	return func(h *Hedger) { h.Options.InitialDelay = d }
+}
+
+// WithMaxInFlight sets Options.MaxInFlight.
+func WithMaxInFlight(n int) Option {
+	return func(h *Hedger) { h.Options.MaxInFlight = n }
+}
+
+// WithAcceptable sets Options.Acceptable.
+func WithAcceptable(f func(v interface{}, err error) bool) Option {
+	return func(h *Hedger) { h.Options.Acceptable = f }
+}
+
+// WithRank sets Options.Rank.
+func WithRank(f func(a, b interface{}) int) Option {
+	return func(h *Hedger) { h.Options.Rank = f }
+}
+
+// WithDeadlineMargin sets Options.DeadlineMargin.
+func WithDeadlineMargin(d time.Duration) Option {
+	return func(h *Hedger) { h.Options.DeadlineMargin = d }
+}
+
+// WithFailFastQuorum sets Options.FailFastQuorum.
+func WithFailFastQuorum(n int) Option {
+	return func(h *Hedger) { h.Options.FailFastQuorum = n }
+}
+
+// WithValidate sets Options.Validate.
+func WithValidate(f func(interface{}) bool) Option {
+	return func(h *Hedger) { h.Options.Validate = f }
+}
+
+// WithLimiter sets Options.Limiter.
+func WithLimiter(l Limiter) Option {
+	return func(h *Hedger) { h.Options.Limiter = l }
+}
+
+// WithBudget sets Options.Budget.
+func WithBudget(b *Budget) Option {
+	return func(h *Hedger) { h.Options.Budget = b }
+}
+
+// WithGauge sets Options.Gauge and Options.MaxLoad.
+func WithGauge(gauge func() float64, maxLoad float64) Option {
+	return func(h *Hedger) {
+		h.Options.Gauge = gauge
+		h.Options.MaxLoad = maxLoad
+	}
+}
+
+// WithShouldHedge sets Options.ShouldHedge.
+func WithShouldHedge(f func() bool) Option {
+	return func(h *Hedger) { h.Options.ShouldHedge = f }
+}
+
+// WithMinUseful sets Options.MinUseful.
+func WithMinUseful(d time.Duration) Option {
+	return func(h *Hedger) { h.Options.MinUseful = d }
+}
+
+// WithGrace sets Options.Grace and Options.Prefer.
+func WithGrace(d time.Duration, prefer func(a, b Result) Result) Option {
+	return func(h *Hedger) {
+		h.Options.Grace = d
+		h.Options.Prefer = prefer
+	}
+}
+
+// WithPreferAttempt sets Options.Grace and Options.PreferAttempt, clearing
+// Options.Prefer, which would otherwise take precedence.
+func WithPreferAttempt(attempt int, grace time.Duration) Option {
+	return func(h *Hedger) {
+		h.Options.Grace = grace
+		h.Options.Prefer = nil
+		h.Options.PreferAttempt = attempt
+	}
+}
+
+// WithReapTimeout sets Options.ReapTimeout.
+func WithReapTimeout(d time.Duration) Option {
+	return func(h *Hedger) { h.Options.ReapTimeout = d }
+}
+
+// WithTracer sets Options.Tracer.
+func WithTracer(t Tracer) Option {
+	return func(h *Hedger) { h.Options.Tracer = t }
+}
+
+// WithTransform sets Options.Transform.
+func WithTransform(f func(ctx context.Context, attempt int) context.Context) Option {
+	return func(h *Hedger) { h.Options.Transform = f }
+}
+
+// WithAnnotate sets Options.Annotate.
+func WithAnnotate(f func(v interface{}, attempt int) interface{}) Option {
+	return func(h *Hedger) { h.Options.Annotate = f }
+}
+
+// WithClock sets Options.Clock.
+func WithClock(c Clock) Option {
+	return func(h *Hedger) { h.Options.Clock = c }
+}
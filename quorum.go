@@ -0,0 +1,82 @@
+package hedged
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoQuorum is returned by RunQuorum when not enough requests agree.
+var ErrNoQuorum = errors.New("hedged: no quorum")
+
+// RunQuorum is like RunAll but returns once k requests succeed with values
+// that agree, according to eq, cancelling the rest. Requests that fail don't
+// count towards any quorum.
+//
+// If quorum can no longer be reached, because too few requests remain to make
+// up any value's shortfall, it returns early with the most common value and
+// ErrNoQuorum. If ctx is done before any request succeeds, the error is
+// ctx.Err().
+func RunQuorum(ctx context.Context, wait time.Duration, n, k int, eq func(a, b interface{}) bool, r Request) (interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type tally struct {
+		v     interface{}
+		count int
+	}
+	var votes []tally
+	// best is the index of the most common value in votes.
+	best := -1
+	remaining := clampN(n) + 1
+	for res := range RunAll(ctx, wait, n, r) {
+		remaining--
+		if res.Err == nil {
+			i := 0
+			for i < len(votes) && !eq(votes[i].v, res.Value) {
+				i++
+			}
+			if i == len(votes) {
+				votes = append(votes, tally{v: res.Value})
+			}
+			votes[i].count++
+			if best < 0 || votes[i].count > votes[best].count {
+				best = i
+			}
+			if votes[best].count >= k {
+				return votes[best].v, nil
+			}
+		}
+		if (best < 0 && remaining < k) || (best >= 0 && votes[best].count+remaining < k) {
+			break
+		}
+	}
+	if best < 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoQuorum
+	}
+	return votes[best].v, ErrNoQuorum
+}
+
+// RunFastestK is like RunAll but returns once k requests complete, cancelling
+// the rest, with their results in completion order, failures included, e.g.
+// for best-of-n aggregation. If fewer than k complete, because k > n+1 or ctx
+// is done first, it returns those that did.
+func RunFastestK(ctx context.Context, wait time.Duration, n, k int, r Request) []Result {
+	if k <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]Result, 0, k)
+	for res := range RunAll(ctx, wait, n, r) {
+		results = append(results, res)
+		if len(results) == k {
+			break
+		}
+	}
+	return results
+}
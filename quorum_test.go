@@ -0,0 +1,99 @@
+package hedged
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func equal(a, b interface{}) bool {
+	return a == b
+}
+
+// values returns a request whose attempts return vs in turn.
+func values(vs ...interface{}) Request {
+	return RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		if err, ok := vs[attempt].(error); ok {
+			return nil, err
+		}
+		return vs[attempt], nil
+	})
+}
+
+func TestRunQuorum(t *testing.T) {
+	r := values("a", "b", "a", "c", "c")
+	v, err := RunQuorum(context.TODO(), 5*time.Millisecond, 4, 2, equal, r)
+	if err != nil || v != "a" {
+		t.Errorf("Expected a, got %v, %v", v, err)
+	}
+}
+
+func TestRunQuorumErrors(t *testing.T) {
+	r := values("a", errHowdy, "a")
+	v, err := RunQuorum(context.TODO(), 5*time.Millisecond, 2, 2, equal, r)
+	if err != nil || v != "a" {
+		t.Errorf("Expected a, got %v, %v", v, err)
+	}
+}
+
+func TestRunQuorumUnreachable(t *testing.T) {
+	r := values("a", "b", "a", "c")
+	v, err := RunQuorum(context.TODO(), 5*time.Millisecond, 3, 3, equal, r)
+	if err != ErrNoQuorum || v != "a" {
+		t.Errorf("Expected a with ErrNoQuorum, got %v, %v", v, err)
+	}
+}
+
+func TestRunQuorumFailuresFirst(t *testing.T) {
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt < 2 {
+			return nil, errHowdy
+		}
+		// A straggler that alone can't make up the quorum.
+		select {
+		case <-time.After(1 * time.Second):
+			return "a", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	start := time.Now()
+	v, err := RunQuorum(context.TODO(), 0, 2, 2, equal, r)
+	if err != ErrNoQuorum || v != nil {
+		t.Errorf("Expected ErrNoQuorum, got %v, %v", v, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected no wait on the straggler, took %v", d)
+	}
+}
+
+func TestRunFastestK(t *testing.T) {
+	cancelled := make(chan int, 4)
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		attempt, _ := AttemptFromContext(ctx)
+		if attempt >= 2 {
+			<-ctx.Done()
+			cancelled <- attempt
+			return nil, ctx.Err()
+		}
+		// Both complete once every request is in flight.
+		time.Sleep(50 * time.Millisecond)
+		return attempt, nil
+	})
+	results := RunFastestK(context.TODO(), 5*time.Millisecond, 3, 2, r)
+	if len(results) != 2 || results[0].Value != 0 || results[1].Value != 1 {
+		t.Fatalf("Expected 0 then 1, got %+v", results)
+	}
+	got := map[int]bool{<-cancelled: true, <-cancelled: true}
+	if !got[2] || !got[3] {
+		t.Errorf("Expected attempts 2 and 3 cancelled, got %v", got)
+	}
+}
+
+func TestRunFastestKTooFew(t *testing.T) {
+	results := RunFastestK(context.TODO(), 1*time.Millisecond, 1, 5, values("a", "b"))
+	if len(results) != 2 {
+		t.Errorf("Expected both results, got %+v", results)
+	}
+}
@@ -0,0 +1,93 @@
+package hedged
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// recorderShards is how many shards a Recorder spreads its samples over, so
+// that concurrent runs rarely contend for the same lock.
+const recorderShards = 16
+
+// Recorder records the latency of runs, as set on Hedger.Recorder, to measure
+// how much hedging cuts the tail: for each run, both its latency with hedging
+// and that of the original request alone, as if no hedge had been sent. It
+// keeps the most recent samples in a rolling window.
+//
+// The original's latency is only known if it returned before the run did.
+// Otherwise, it is cancelled once a hedge wins, and its latency is counted as
+// the run's, a lower bound, so the tail without hedging is understated.
+//
+// The zero value keeps DefaultWindow samples. A Recorder is safe for
+// concurrent use, spreading samples over shards, each with its own lock.
+type Recorder struct {
+	// Window is the number of recent runs to keep. Zero means
+	// DefaultWindow. It must not change once in use.
+	Window int
+
+	next   uint32
+	shards [recorderShards]recorderShard
+}
+
+// recorderShard holds the samples of some of the runs, in rolling windows.
+type recorderShard struct {
+	mu       sync.Mutex
+	hedged   []time.Duration
+	unhedged []time.Duration
+	next     int
+}
+
+// Record records a run that took d with hedging, and original by the
+// original request alone.
+func (r *Recorder) Record(d, original time.Duration) {
+	window := r.Window
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	// Each shard keeps its share of the window, rounded up.
+	window = (window + recorderShards - 1) / recorderShards
+	s := &r.shards[atomic.AddUint32(&r.next, 1)%recorderShards]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.hedged) < window {
+		s.hedged = append(s.hedged, d)
+		s.unhedged = append(s.unhedged, original)
+		return
+	}
+	s.hedged[s.next], s.unhedged[s.next] = d, original
+	s.next = (s.next + 1) % len(s.hedged)
+}
+
+// record records a run from its stats.
+func (r *Recorder) record(stats *Stats) {
+	original := stats.Elapsed
+	if len(stats.Durations) > 0 && stats.Durations[0] > 0 {
+		original = stats.Durations[0]
+	}
+	r.Record(stats.Elapsed, original)
+}
+
+// Percentile returns the p percentile, in (0, 1], of the latencies of the
+// runs recorded, with hedging, or zero if none are.
+func (r *Recorder) Percentile(p float64) time.Duration {
+	return percentile(r.samples(func(s *recorderShard) []time.Duration { return s.hedged }), p)
+}
+
+// UnhedgedPercentile is like Percentile but for the latencies of the original
+// requests alone.
+func (r *Recorder) UnhedgedPercentile(p float64) time.Duration {
+	return percentile(r.samples(func(s *recorderShard) []time.Duration { return s.unhedged }), p)
+}
+
+// samples returns a copy of the samples picked from every shard.
+func (r *Recorder) samples(pick func(*recorderShard) []time.Duration) []time.Duration {
+	var all []time.Duration
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.Lock()
+		all = append(all, pick(s)...)
+		s.mu.Unlock()
+	}
+	return all
+}
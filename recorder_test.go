@@ -0,0 +1,69 @@
+package hedged
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecorderPercentile(t *testing.T) {
+	var r Recorder
+	if d := r.Percentile(0.5); d != 0 {
+		t.Errorf("Expected 0 before recording, got %v", d)
+	}
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d := time.Duration(i) * time.Millisecond
+			r.Record(d, 2*d)
+		}(i)
+	}
+	wg.Wait()
+	for _, tt := range []struct {
+		p                float64
+		hedged, unhedged time.Duration
+	}{
+		{0.5, 50 * time.Millisecond, 100 * time.Millisecond},
+		{0.99, 99 * time.Millisecond, 198 * time.Millisecond},
+		{1, 100 * time.Millisecond, 200 * time.Millisecond},
+	} {
+		if d := r.Percentile(tt.p); d != tt.hedged {
+			t.Errorf("Expected p%v of %v, got %v", tt.p*100, tt.hedged, d)
+		}
+		if d := r.UnhedgedPercentile(tt.p); d != tt.unhedged {
+			t.Errorf("Expected unhedged p%v of %v, got %v", tt.p*100, tt.unhedged, d)
+		}
+	}
+}
+
+func TestRecorderWindow(t *testing.T) {
+	r := Recorder{Window: 32}
+	for i := 1; i <= 64; i++ {
+		d := time.Duration(i) * time.Millisecond
+		r.Record(d, d)
+	}
+	// Only the 32 most recent, 33ms through 64ms, remain.
+	if d := r.Percentile(0); d != 33*time.Millisecond {
+		t.Errorf("Expected 33ms, got %v", d)
+	}
+}
+
+func TestHedgerRecorder(t *testing.T) {
+	h := New(WithWait(1*time.Millisecond), WithRecorder(&Recorder{}))
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		time.Sleep(10 * time.Millisecond)
+		return "hedge", nil
+	})
+	h.Run(context.TODO(), r)
+	hedged, unhedged := h.Recorder.Percentile(1), h.Recorder.UnhedgedPercentile(1)
+	if hedged < 10*time.Millisecond || unhedged < hedged {
+		t.Errorf("Expected at least 10ms, and no less unhedged, got %v and %v", hedged, unhedged)
+	}
+}
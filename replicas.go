@@ -0,0 +1,128 @@
+package hedged
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// ErrNoReplicas is returned when there are no replicas to send requests to.
+var ErrNoReplicas = errors.New("hedged: no replicas")
+
+// RunReplicas is like RunN but spreads the requests across replicas: the
+// original goes to rs[0], and each hedge to the next replica in turn, one hedge
+// per remaining replica.
+func RunReplicas(ctx context.Context, wait time.Duration, rs []Request) interface{} {
+	return RunReplicasN(ctx, wait, len(rs)-1, rs)
+}
+
+// RunHetero is like RunReplicas but takes the requests as arguments, e.g. to
+// hedge an RPC with a cache lookup implemented quite differently: rs[0] is
+// sent first, and each of the rest on each hedge tick in turn. The first to
+// complete wins, whichever it is, and the rest are cancelled.
+func RunHetero(ctx context.Context, wait time.Duration, rs ...Request) interface{} {
+	return RunReplicas(ctx, wait, rs)
+}
+
+// RunReplicasN is like RunReplicas but sends n hedges, cycling back to rs[0]
+// if there are more hedges than replicas.
+func RunReplicasN(ctx context.Context, wait time.Duration, n int, rs []Request) interface{} {
+	if len(rs) == 0 {
+		return ErrNoReplicas
+	}
+	return RunN(ctx, wait, n, replicas(rs))
+}
+
+// RunReplicasDelayFor is like RunReplicas but times each hedge by the replica
+// it goes to, e.g. to hedge to a fast replica sooner than to a slow one: it
+// waits delayFor(attempt, replica) before sending the hedge with that index, 1
+// for the first, to that replica. A nil delayFor waits wait before each, as
+// RunReplicas does.
+func RunReplicasDelayFor(ctx context.Context, wait time.Duration, rs []Request, delayFor func(attempt int, replica Request) time.Duration) interface{} {
+	if len(rs) == 0 {
+		return ErrNoReplicas
+	}
+	var o Options
+	if delayFor != nil {
+		o.Backoff = func(attempt int, _ time.Duration) time.Duration {
+			return delayFor(attempt, rs[attempt%len(rs)])
+		}
+	}
+	return run(ctx, wait, len(rs)-1, replicas(rs), &o).value()
+}
+
+// WeightedRequest is a replica with a weight, for RunWeighted.
+type WeightedRequest struct {
+	Request
+
+	// Weight is the replica's share of hedges, relative to the others. A
+	// replica with no weight is hedged to last.
+	Weight float64
+}
+
+// RunWeighted is like RunReplicas but picks the replica for each hedge by
+// weighted sampling without replacement, so that duplicated work goes to the
+// replicas best able to absorb it. The original still goes to rs[0].
+func RunWeighted(ctx context.Context, wait time.Duration, rs []WeightedRequest) interface{} {
+	if len(rs) == 0 {
+		return ErrNoReplicas
+	}
+	return RunN(ctx, wait, len(rs)-1, weightedOrder(rs, rand.Float64))
+}
+
+// weightedOrder orders rs[1:] by weighted sampling without replacement, after
+// rs[0]. Each replica draws the key u^(1/weight), for u uniform in [0, 1), and
+// the keys sort in descending order.
+func weightedOrder(rs []WeightedRequest, random func() float64) replicas {
+	keys := make([]float64, len(rs))
+	order := make([]int, len(rs))
+	for i, r := range rs {
+		order[i] = i
+		if r.Weight > 0 {
+			keys[i] = math.Pow(random(), 1/r.Weight)
+		} else {
+			keys[i] = -1
+		}
+	}
+	hedges := order[1:]
+	sort.SliceStable(hedges, func(i, j int) bool {
+		return keys[hedges[i]] > keys[hedges[j]]
+	})
+	out := make(replicas, len(rs))
+	for i, j := range order {
+		out[i] = rs[j].Request
+	}
+	return out
+}
+
+// RunSpeculative sends a cheap speculative request and, right after it, an
+// expensive authoritative one, and returns whichever result it can trust
+// soonest: the authoritative result as soon as it succeeds, or else the
+// speculative one, but only once grace has elapsed after it without the
+// authoritative one contradicting it.
+//
+// The speculative result is thus held for up to grace, and swapped for the
+// authoritative one if that succeeds meanwhile, whether or not it differs.
+// A failure of either never wins over a success of the other, so a failed
+// speculative request waits on the authoritative one past grace. If both fail,
+// the error is an Errors holding both errors. Whichever request is still in
+// flight when the result is returned is cancelled.
+func RunSpeculative(ctx context.Context, speculative, authoritative Request, grace time.Duration) (interface{}, error) {
+	res := run(ctx, 0, 1, replicas{speculative, authoritative}, &Options{
+		firstSuccess:  true,
+		Grace:         grace,
+		PreferAttempt: 1,
+	})
+	return res.Value, res.Err
+}
+
+// replicas routes each request to a replica by its index.
+type replicas []Request
+
+func (rs replicas) Req(ctx context.Context) (interface{}, error) {
+	attempt, _ := AttemptFromContext(ctx)
+	return rs[attempt%len(rs)].Req(ctx)
+}
@@ -0,0 +1,176 @@
+package hedged
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+type replica struct {
+	mu    sync.Mutex
+	name  string
+	calls int
+	slow  bool
+}
+
+func (r *replica) Req(ctx context.Context) (interface{}, error) {
+	r.mu.Lock()
+	r.calls++
+	r.mu.Unlock()
+	if r.slow {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return r.name, nil
+}
+
+func (r *replica) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+func TestRunReplicas(t *testing.T) {
+	a := &replica{name: "a", slow: true}
+	b := &replica{name: "b", slow: true}
+	c := &replica{name: "c"}
+	v := RunReplicas(context.TODO(), 1*time.Millisecond, []Request{a, b, c})
+	if v != "c" {
+		t.Errorf("Expected c, got %v", v)
+	}
+	if a.count() != 1 || b.count() != 1 || c.count() != 1 {
+		t.Errorf("Expected one call each, got %d, %d, %d", a.count(), b.count(), c.count())
+	}
+}
+
+func TestRunHetero(t *testing.T) {
+	rpc := &replica{name: "rpc", slow: true}
+	cache := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		return "cache", nil
+	})
+	if v := RunHetero(context.TODO(), 1*time.Millisecond, rpc, cache); v != "cache" {
+		t.Errorf("Expected cache, got %v", v)
+	}
+	if v := RunHetero(context.TODO(), 1*time.Millisecond); v != ErrNoReplicas {
+		t.Errorf("Expected ErrNoReplicas, got %v", v)
+	}
+}
+
+func TestRunReplicasCycle(t *testing.T) {
+	a := &replica{name: "a", slow: true}
+	b := &replica{name: "b", slow: true}
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	v := RunReplicasN(ctx, 1*time.Millisecond, 4, []Request{a, b})
+	if v != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", v)
+	}
+	if a.count() != 3 || b.count() != 2 {
+		t.Errorf("Expected 3 and 2 calls, got %d and %d", a.count(), b.count())
+	}
+}
+
+func TestRunReplicasDelayFor(t *testing.T) {
+	a := &replica{name: "a", slow: true}
+	b := &replica{name: "b", slow: true}
+	c := &replica{name: "c"}
+	delays := map[Request]time.Duration{b: 1 * time.Millisecond, c: 2 * time.Millisecond}
+	got := make(map[Request]time.Duration)
+	delayFor := func(attempt int, replica Request) time.Duration {
+		got[replica] = delays[replica]
+		return delays[replica]
+	}
+	start := time.Now()
+	v := RunReplicasDelayFor(context.TODO(), 1*time.Hour, []Request{a, b, c}, delayFor)
+	if v != "c" {
+		t.Errorf("Expected c, got %v", v)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected the replicas' delays rather than the wait, took %v", d)
+	}
+	if len(got) != 2 || got[b] != 1*time.Millisecond || got[c] != 2*time.Millisecond {
+		t.Errorf("Expected 1ms for b and 2ms for c, got %v", got)
+	}
+	if v := RunReplicasDelayFor(context.TODO(), 1*time.Millisecond, []Request{a, c}, nil); v != "c" {
+		t.Errorf("Expected c, got %v", v)
+	}
+}
+
+func TestRunReplicasEmpty(t *testing.T) {
+	if v := RunReplicas(context.TODO(), 1*time.Millisecond, nil); v != ErrNoReplicas {
+		t.Errorf("Expected ErrNoReplicas, got %v", v)
+	}
+}
+
+func TestRunSpeculative(t *testing.T) {
+	value := func(v interface{}, err error, delay time.Duration) Request {
+		return RequestFunc(func(ctx context.Context) (interface{}, error) {
+			select {
+			case <-time.After(delay):
+				return v, err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+	}
+	for _, tt := range []struct {
+		name                       string
+		speculative, authoritative Request
+		grace                      time.Duration
+		want                       interface{}
+	}{
+		{"Swapped", value("cached", nil, 0), value("fresh", nil, 5*time.Millisecond), 1 * time.Second, "fresh"},
+		{"Confirmed", value("cached", nil, 0), value("fresh", nil, 1*time.Second), 5 * time.Millisecond, "cached"},
+		{"SpeculativeFailed", value(nil, errHowdy, 0), value("fresh", nil, 20*time.Millisecond), 1 * time.Millisecond, "fresh"},
+		{"AuthoritativeFailed", value("cached", nil, 5*time.Millisecond), value(nil, errHowdy, 0), 1 * time.Second, "cached"},
+		{"AuthoritativeFirst", value("cached", nil, 1*time.Hour), value("fresh", nil, 0), 1 * time.Second, "fresh"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			v, err := RunSpeculative(context.TODO(), tt.speculative, tt.authoritative, tt.grace)
+			if err != nil || v != tt.want {
+				t.Errorf("Expected %v, got %v, %v", tt.want, v, err)
+			}
+			if d := time.Since(start); d > 500*time.Millisecond {
+				t.Errorf("Expected no wait on the request still in flight, took %v", d)
+			}
+		})
+	}
+}
+
+func TestWeightedOrder(t *testing.T) {
+	p := &replica{name: "primary"}
+	light := &replica{name: "light"}
+	heavy := &replica{name: "heavy"}
+	none := &replica{name: "none"}
+	rs := []WeightedRequest{{p, 1}, {light, 1}, {heavy, 3}, {none, 0}}
+	rng := rand.New(rand.NewSource(1))
+
+	const runs = 10000
+	first := make(map[Request]int)
+	for i := 0; i < runs; i++ {
+		order := weightedOrder(rs, rng.Float64)
+		if order[0] != p {
+			t.Fatalf("Expected the primary first, got %v", order[0])
+		}
+		if order[3] != none {
+			t.Fatalf("Expected the unweighted replica last, got %v", order[3])
+		}
+		first[order[1]]++
+	}
+	// The heavy replica takes the first hedge 3 times in 4.
+	if share := float64(first[heavy]) / runs; share < 0.72 || share > 0.78 {
+		t.Errorf("Expected the heavy replica first in about 75%% of runs, got %.3f", share)
+	}
+}
+
+func TestRunWeighted(t *testing.T) {
+	a := &replica{name: "a", slow: true}
+	b := &replica{name: "b"}
+	v := RunWeighted(context.TODO(), 1*time.Millisecond, []WeightedRequest{{a, 1}, {b, 1}})
+	if v != "b" {
+		t.Errorf("Expected b, got %v", v)
+	}
+}
@@ -0,0 +1,70 @@
+package hedged
+
+import (
+	"context"
+	"time"
+)
+
+// Stream is a stream of messages, e.g. from a gRPC server-streaming call or a
+// chunked HTTP response. Recv returns the next message, or an error once
+// there are no more, such as io.EOF.
+type Stream interface {
+	Recv() (interface{}, error)
+}
+
+// RunStream is like RunNE but hedges the opening of a stream: each request
+// opens one with open and waits for its first message, and the first stream
+// to yield it wins. The other streams are cancelled, while the winner's
+// context lives on until its Recv returns an error, or ctx is done.
+//
+// The winning stream is returned with its first message still to be received.
+// A stream that fails to open, or whose Recv fails before yielding any
+// message, io.EOF included, loses. If every request fails, the error is an
+// Errors holding the error of each, as with RunFirstSuccess.
+func RunStream(ctx context.Context, wait time.Duration, n int, open func(context.Context) (Stream, error)) (Stream, error) {
+	r := RequestFunc(func(ctx context.Context) (interface{}, error) {
+		s, err := open(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v, err := s.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return &stream{Stream: s, first: v, pending: true}, nil
+	})
+	res := run(ctx, wait, n, r, &Options{firstSuccess: true, keepWinner: true})
+	release := res.release
+	if release == nil {
+		release = func() {}
+	}
+	if res.Err != nil {
+		release()
+		return nil, res.Err
+	}
+	s := res.Value.(*stream)
+	s.release = release
+	return s, nil
+}
+
+// stream replays the first message of the winning stream, and releases its
+// context once it ends.
+type stream struct {
+	Stream
+	first   interface{}
+	pending bool
+	release func()
+}
+
+func (s *stream) Recv() (interface{}, error) {
+	if s.pending {
+		v := s.first
+		s.pending, s.first = false, nil
+		return v, nil
+	}
+	v, err := s.Stream.Recv()
+	if err != nil {
+		s.release()
+	}
+	return v, err
+}
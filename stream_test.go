@@ -0,0 +1,115 @@
+package hedged
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// chunks is a Stream of the given messages, each after delay, that ends with
+// the error of its context once cancelled.
+type chunks struct {
+	ctx   context.Context
+	msgs  []interface{}
+	delay time.Duration
+}
+
+func (c *chunks) Recv() (interface{}, error) {
+	if len(c.msgs) == 0 {
+		return nil, io.EOF
+	}
+	select {
+	case <-time.After(c.delay):
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+	v := c.msgs[0]
+	c.msgs = c.msgs[1:]
+	return v, nil
+}
+
+func TestRunStream(t *testing.T) {
+	ctxs := make(chan context.Context, 2)
+	s, err := RunStream(context.TODO(), 5*time.Millisecond, 1, func(ctx context.Context) (Stream, error) {
+		ctxs <- ctx
+		// The original is slow to yield its first message.
+		delay := 1 * time.Second
+		if attempt, _ := AttemptFromContext(ctx); attempt == 1 {
+			delay = 0
+		}
+		return &chunks{ctx, []interface{}{"a", "b"}, delay}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, hedge := <-ctxs, <-ctxs
+	if original.Err() == nil {
+		t.Error("Expected the original cancelled")
+	}
+	var got []interface{}
+	for {
+		v, err := s.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hedge.Err() != nil {
+			t.Fatal("Expected the winner's context alive while streaming")
+		}
+		got = append(got, v)
+	}
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected a, b, got %v", got)
+	}
+	if hedge.Err() == nil {
+		t.Error("Expected the winner's context released at the end")
+	}
+}
+
+func TestRunStreamError(t *testing.T) {
+	_, err := RunStream(context.TODO(), 0, 1, func(ctx context.Context) (Stream, error) {
+		return nil, errHowdy
+	})
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0] != errHowdy || errs[1] != errHowdy {
+		t.Errorf("Expected errHowdy from both, got %v", err)
+	}
+}
+
+func TestRunStreamFailureLoses(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// fail is what the original does: fail to open, or end at once.
+		fail func(ctx context.Context) (Stream, error)
+	}{
+		{"Open", func(ctx context.Context) (Stream, error) {
+			time.Sleep(5 * time.Millisecond)
+			return nil, errHowdy
+		}},
+		{"FirstRecv", func(ctx context.Context) (Stream, error) {
+			return &chunks{ctx: ctx}, nil
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := RunStream(context.TODO(), 0, 1, func(ctx context.Context) (Stream, error) {
+				if attempt, _ := AttemptFromContext(ctx); attempt == 0 {
+					return tt.fail(ctx)
+				}
+				return &chunks{ctx, []interface{}{"a"}, 20 * time.Millisecond}, nil
+			})
+			if err != nil {
+				t.Fatalf("Expected the healthy stream to win, got %v", err)
+			}
+			if v, err := s.Recv(); err != nil || v != "a" {
+				t.Errorf("Expected a, got %v, %v", v, err)
+			}
+			if _, err := s.Recv(); err != io.EOF {
+				t.Errorf("Expected io.EOF, got %v", err)
+			}
+		})
+	}
+}